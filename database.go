@@ -2,6 +2,7 @@ package data
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/modest-sql/common"
 )
@@ -22,14 +24,34 @@ type dbInfo struct {
 	columns              int64
 	defaultNumerics      int64
 	defaultChars         int64
+	// Record blocks of system tables created after the database, nullBlockAddr until then.
+	statisticsRecordBlock        int64
+	viewsRecordBlock             int64
+	materializedViewsRecordBlock int64
+	uniquesRecordBlock           int64
+	checksRecordBlock            int64
+	// Sequence number of the last change event.
+	changeSequence       int64
+	sequencesRecordBlock int64
+	formatVersion        int64
 }
 
 type Database struct {
 	dbInfo
-	dbTableIDs  map[string]dbInteger
-	dbTables    []dbTable
-	dbSysTables []dbTable
-	dbFile      *os.File
+	dbTableIDs          map[string]dbInteger
+	dbTables            []dbTable
+	dbSysTables         []dbTable
+	dbViews             map[string]*dbView
+	dbMaterializedViews map[string]*dbMaterializedView
+	dbTriggers          map[string][]dbTrigger
+	dbSequences         map[string]*dbSequence
+	currentValues       map[string]int64
+	dbFile              *os.File
+	journal             *dbJournal
+	parallelism         int
+	changes             *changeFeed
+	pendingChanges      []ChangeEvent
+	heldTables          map[string]TriggerEvent
 }
 
 func NewDatabase(path string, blockSize int64) (*Database, error) {
@@ -42,7 +64,7 @@ func NewDatabase(path string, blockSize int64) (*Database, error) {
 		return nil, err
 	}
 
-	dbInfo := dbInfo{blockSize: blockSize, blocks: 1}
+	dbInfo := dbInfo{blockSize: blockSize, blocks: 1, formatVersion: currentFormatVersion}
 	db := newDatabase(dbInfo, dbFile)
 
 	if err := db.writeDbInfo(); err != nil {
@@ -84,30 +106,55 @@ func LoadDatabase(path string) (*Database, error) {
 		return nil, err
 	}
 
+	if err := db.migrate(); err != nil {
+		return nil, err
+	}
+
+	if err := db.loadStatistics(); err != nil {
+		return nil, err
+	}
+
+	if err := db.loadUniques(); err != nil {
+		return nil, err
+	}
+
+	if err := db.loadChecks(); err != nil {
+		return nil, err
+	}
+
+	if err := db.loadViews(); err != nil {
+		return nil, err
+	}
+
+	if err := db.loadMaterializedViews(); err != nil {
+		return nil, err
+	}
+
+	if err := db.loadSequences(); err != nil {
+		return nil, err
+	}
+
 	return db, nil
 }
 
 func (db *Database) NewTable(name string, columnDefiners []common.TableColumnDefiner) error {
-	tableName := make(dbChar, maxNameLength)
-	copy(tableName, name)
+	return db.NewTableContext(context.Background(), name, columnDefiners)
+}
 
-	firstRecordBlockAddr, err := db.allocBlock()
+func (db *Database) NewTableContext(ctx context.Context, name string, columnDefiners []common.TableColumnDefiner) error {
+	return db.atomically(func() error {
+		return db.newTable(ctx, name, columnDefiners)
+	})
+}
+
+func (db *Database) newTable(ctx context.Context, name string, columnDefiners []common.TableColumnDefiner) error {
+	table, err := db.allocTable(name)
 	if err != nil {
 		return err
 	}
 
-	tableID := dbInteger(db.tables + 1)
-
-	values := map[string]dbType{
-		"TABLE_ID":           tableID,
-		"FIRST_RECORD_BLOCK": dbInteger(firstRecordBlockAddr),
-		"TABLE_NAME":         tableName,
-	}
-
-	table := newDBTable(tableID, tableName, []dbColumn{}, dbInteger(firstRecordBlockAddr))
-
 	for pos, definition := range columnDefiners {
-		column, err := db.newDBColumn(table, definition, pos)
+		column, err := db.newDBColumn(ctx, table, definition, pos)
 		if err != nil {
 			return err
 		}
@@ -117,16 +164,50 @@ func (db *Database) NewTable(name string, columnDefiners []common.TableColumnDef
 		}
 	}
 
+	return db.storeTable(ctx, table)
+}
+
+// allocTable creates an empty table called name with its first record block allocated.
+func (db *Database) allocTable(name string) (dbTable, error) {
+	if _, ok := db.dbViews[name]; ok {
+		return dbTable{}, fmt.Errorf("View `%s' already exists in Database `%s'", name, db.name())
+	}
+
+	if table, _ := db.table(name); table != nil {
+		return dbTable{}, fmt.Errorf("Duplicate table `%s' in Database `%s'", name, db.name())
+	}
+
+	tableName := make(dbChar, maxNameLength)
+	copy(tableName, name)
+
+	firstRecordBlockAddr, err := db.allocBlock()
+	if err != nil {
+		return dbTable{}, err
+	}
+
+	tableID := dbInteger(db.tables + 1)
+	return newDBTable(tableID, tableName, []dbColumn{}, dbInteger(firstRecordBlockAddr)), nil
+}
+
+// storeTable adds a table created by allocTable, and its columns, to the catalog.
+func (db *Database) storeTable(ctx context.Context, table dbTable) error {
 	if err := db.addTable(table); err != nil {
 		return err
 	}
+	db.recordChange(table, ChangeCreateTable, nil, nil)
 
 	db.tables++
 	if err := db.writeDbInfo(); err != nil {
 		return err
 	}
 
-	if err := db.insert(db.sysTables(), values); err != nil {
+	values := map[string]dbType{
+		"TABLE_ID":           table.dbTableID,
+		"FIRST_RECORD_BLOCK": table.firstRecordBlockAddr,
+		"TABLE_NAME":         table.dbTableName,
+	}
+
+	if err := db.insert(ctx, db.sysTables(), values); err != nil {
 		return err
 	}
 
@@ -143,7 +224,7 @@ func (db *Database) NewTable(name string, columnDefiners []common.TableColumnDef
 			"COLUMN_NAME":           column.dbColumnName,
 		}
 
-		if err := db.insert(db.sysColumns(), values); err != nil {
+		if err := db.insert(ctx, db.sysColumns(), values); err != nil {
 			return err
 		}
 	}
@@ -153,42 +234,122 @@ func (db *Database) NewTable(name string, columnDefiners []common.TableColumnDef
 		return err
 	}
 
-	if err := db.writeAt(table.recordBlockBytes(rb), firstRecordBlockAddr); err != nil {
+	return db.writeAt(table.recordBlockBytes(rb), int64(table.firstRecordBlockAddr))
+}
+
+// writeTableValue stores value in the field of the SYS_TABLES row of table.
+func (db *Database) writeTableValue(ctx context.Context, table dbTable, field string, value dbType) error {
+	sysTables := db.sysTables()
+	column, err := sysTables.column(field)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	err = db.scanRecordBlocks(ctx, sysTables, func(addr int64, rb dbRecordBlock) (bool, error) {
+		for i := range rb.dbRecords {
+			if rb.dbRecords[i].isFree() || rb.dbRecords[i].dbTuple["SYS_TABLES.TABLE_ID"] != table.dbTableID {
+				continue
+			}
+
+			found = true
+			rb.dbRecords[i].insertColumnValue(value, *column)
+			return false, db.writeAt(sysTables.recordBlockBytes(rb), addr)
+		}
+		return true, nil
+	})
+	if err != nil {
 		return err
 	}
 
+	if !found {
+		return fmt.Errorf("Table `%s' is missing from SYS_TABLES", table.name())
+	}
 	return nil
 }
 
 func (db *Database) Insert(name string, values map[string]interface{}) error {
-	table, err := db.table(name)
+	return db.InsertContext(context.Background(), name, values)
+}
+
+func (db *Database) InsertContext(ctx context.Context, name string, values map[string]interface{}) error {
+	table, err := db.writableTable(name)
 	if err != nil {
 		return err
 	}
 
-	dbValues, err := convertValuesMap(*table, values)
+	return db.atomically(func() error {
+		values, err := db.resolveSequences(ctx, *table, values, true)
+		if err != nil {
+			return err
+		}
+
+		dbValues, err := convertValuesMap(*table, values)
+		if err != nil {
+			return err
+		}
+
+		return db.insert(ctx, *table, dbValues)
+	})
+}
+
+func (db *Database) insert(ctx context.Context, table dbTable, values map[string]dbType) error {
+	tuple, err := db.insertRow(ctx, table, values)
 	if err != nil {
 		return err
 	}
 
-	return db.insert(*table, dbValues)
+	if err := db.checkUniques(ctx, table, []dbTuple{tuple}); err != nil {
+		return err
+	}
+
+	return db.rowChanged(ctx, table, nil, tuple)
 }
 
-func (db *Database) insert(table dbTable, values map[string]dbType) error {
+/*
+insertRow stores the row built from values once the BEFORE INSERT triggers have run and
+returns it. Checking the UNIQUE constraints and reporting the change are left to the caller.
+*/
+func (db *Database) insertRow(ctx context.Context, table dbTable, values map[string]dbType) (dbTuple, error) {
 	record, err := table.buildDBRecord(values)
 	if err != nil {
+		return nil, err
+	}
+
+	if values, err := db.fireTriggers(table, BeforeInsert, nil, record.dbTuple); err != nil {
+		return nil, err
+	} else if values != nil {
+		if record, err = table.buildDBRecord(values); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := table.checkRow(record.dbTuple); err != nil {
+		return nil, err
+	}
+
+	return record.dbTuple, db.insertRecord(ctx, table, record)
+}
+
+// insertRecord stores record in the first record block of the table with a free slot.
+func (db *Database) insertRecord(ctx context.Context, table dbTable, record dbRecord) error {
+	if err := table.checkRecordFits(record, db.blockSize); err != nil {
 		return err
 	}
 
 	lastAddr := nullBlockAddr
 	for addr := int64(table.firstRecordBlockAddr); addr != nullBlockAddr; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		block, err := db.readAt(addr)
 		if err != nil {
 			return err
 		}
 
 		rb := table.loadRecordBlockBytes(block)
-		if rb.insertRecord(record) {
+		if table.insertRecord(&rb, record) {
 			return db.writeAt(table.recordBlockBytes(rb), addr)
 		}
 
@@ -216,21 +377,42 @@ func (db *Database) insert(table dbTable, values map[string]dbType) error {
 		return err
 	}
 
-	rb.insertRecord(record)
+	table.insertRecord(&rb, record)
 	return db.writeAt(table.recordBlockBytes(rb), newAddr)
 }
 
-func (db *Database) Delete(name string, condition common.Expression) error {
-	table, err := db.table(name)
+func (db *Database) Delete(name string, condition common.Expression, options ...ModifyOption) (*ModifyResult, error) {
+	return db.DeleteContext(context.Background(), name, condition, options...)
+}
+
+// DeleteContext deletes the rows of the table name satisfying condition, or all of them when it is nil.
+func (db *Database) DeleteContext(ctx context.Context, name string, condition common.Expression, options ...ModifyOption) (*ModifyResult, error) {
+	table, err := db.writableTable(name)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	result := newModifyResult(options)
+	if err := db.atomically(func() error {
+		return db.delete(ctx, *table, condition, result)
+	}); err != nil {
+		return nil, err
 	}
 
-	return db.delete(*table, condition)
+	return result, nil
 }
 
-func (db *Database) delete(table dbTable, condition common.Expression) error {
+func (db *Database) delete(ctx context.Context, table dbTable, condition common.Expression, result *ModifyResult) error {
+	resetSubqueries(ctx, condition)
+
+	// The change is reported after the scan, so that rows written by AFTER triggers aren't
+	// met and deleted too.
+	deleted := []dbTuple{}
 	for blockAddr := int64(table.firstRecordBlockAddr); blockAddr != nullBlockAddr; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		block, err := db.readAt(blockAddr)
 		if err != nil {
 			return err
@@ -239,11 +421,26 @@ func (db *Database) delete(table dbTable, condition common.Expression) error {
 
 		// Free all records
 		for index := range recordBlock.dbRecords {
+			// Free slots hold stale tuples the condition must not see
+			if recordBlock.dbRecords[index].isFree() {
+				continue
+			}
+
 			// Set freeFlag on tuple
 			symbols := recordBlock.dbRecords[index].dbTuple.stdMap()
-			if condition == nil || condition.Evaluate(symbols).(bool) {
-				recordBlock.dbRecords[index].freeFlag = freeFlag
+			if condition != nil {
+				if ok, err := evaluatePredicate(condition, symbols); err != nil {
+					return err
+				} else if !ok {
+					continue
+				}
+			}
+
+			if _, err := db.fireTriggers(table, BeforeDelete, recordBlock.dbRecords[index].dbTuple, nil); err != nil {
+				return err
 			}
+			recordBlock.dbRecords[index].freeFlag = freeFlag
+			deleted = append(deleted, recordBlock.dbRecords[index].dbTuple)
 		}
 		// Serialize record block
 		freeBlock := table.recordBlockBytes(recordBlock)
@@ -254,21 +451,63 @@ func (db *Database) delete(table dbTable, condition common.Expression) error {
 		}
 		blockAddr = recordBlock.nextRecordBlock
 	}
+
+	for _, tuple := range deleted {
+		result.add(tuple, nil)
+		if err := db.rowChanged(ctx, table, tuple, nil); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (db *Database) Update(cmd *common.UpdateTableCommand) error {
-	table, err := db.table(cmd.TableName())
+func (db *Database) Update(cmd *common.UpdateTableCommand, options ...ModifyOption) (*ModifyResult, error) {
+	return db.UpdateContext(context.Background(), cmd, options...)
+}
+
+func (db *Database) UpdateContext(ctx context.Context, cmd *common.UpdateTableCommand, options ...ModifyOption) (*ModifyResult, error) {
+	table, err := db.writableTable(cmd.TableName())
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return db.update(*table, cmd)
+	result := newModifyResult(options)
+	if err := db.atomically(func() error {
+		return db.update(ctx, *table, cmd, result)
+	}); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// updateCommand is the part of common.UpdateTableCommand that update uses.
+type updateCommand interface {
+	Condition() common.Expression
+	Values(symbols map[string]interface{}) map[string]interface{}
 }
 
-func (db *Database) update(table dbTable, cmd *common.UpdateTableCommand) error {
+func (db *Database) update(ctx context.Context, table dbTable, cmd updateCommand, result *ModifyResult) error {
+	resetSubqueries(ctx, cmd.Condition())
+
+	// The old and new images of the updated rows. Rows may swap unique keys, so the
+	// constraints are checked once all are updated, and the change is reported after the
+	// scan, so that rows written by AFTER triggers aren't met and updated again.
+	updated := [][2]dbTuple{}
+
+	// Rows that grew too large for their slotted block are moved once all blocks are
+	// scanned, so that the scan doesn't meet them again.
+	type relocation struct {
+		old    dbTuple
+		record dbRecord
+	}
+	relocated := []relocation{}
 
 	for addr := int64(table.firstRecordBlockAddr); addr != nullBlockAddr; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		block, err := db.readAt(addr)
 		if err != nil {
 			return err
@@ -277,12 +516,29 @@ func (db *Database) update(table dbTable, cmd *common.UpdateTableCommand) error
 		rb := table.loadRecordBlockBytes(block)
 		for i := range rb.dbRecords {
 			if !rb.dbRecords[i].isFree() {
-				if cmd.Condition() == nil || cmd.Condition().Evaluate(rb.dbRecords[i].dbTuple.stdMap()).(bool) {
-					dbValues, err := convertValuesMap(table, cmd.Values(rb.dbRecords[i].dbTuple.stdMap()))
+				matches := cmd.Condition() == nil
+				if !matches {
+					if matches, err = evaluatePredicate(cmd.Condition(), rb.dbRecords[i].dbTuple.stdMap()); err != nil {
+						return err
+					}
+				}
+
+				if matches {
+					values, err := db.resolveSequences(ctx, table, cmd.Values(rb.dbRecords[i].dbTuple.stdMap()), false)
+					if err != nil {
+						return err
+					}
+
+					dbValues, err := convertValuesMap(table, values)
 					if err != nil {
 						return err
 					}
 
+					old := dbTuple{}
+					for key, value := range rb.dbRecords[i].dbTuple {
+						old[key] = value
+					}
+
 					for key, value := range dbValues {
 						column, err := table.column(key)
 						if err != nil {
@@ -291,6 +547,31 @@ func (db *Database) update(table dbTable, cmd *common.UpdateTableCommand) error
 
 						rb.dbRecords[i].insertColumnValue(value, *column)
 					}
+
+					if values, err := db.fireTriggers(table, BeforeUpdate, old, rb.dbRecords[i].dbTuple); err != nil {
+						return err
+					} else if values != nil {
+						for key, value := range values {
+							column, err := table.column(key)
+							if err != nil {
+								return err
+							}
+
+							rb.dbRecords[i].insertColumnValue(value, *column)
+						}
+					}
+
+					if err := table.checkRow(rb.dbRecords[i].dbTuple); err != nil {
+						return err
+					}
+
+					if !table.fits(rb) {
+						relocated = append(relocated, relocation{old: old, record: rb.dbRecords[i]})
+						rb.dbRecords[i].freeFlag = freeFlag
+						continue
+					}
+
+					updated = append(updated, [2]dbTuple{old, rb.dbRecords[i].dbTuple})
 				}
 			}
 		}
@@ -302,6 +583,29 @@ func (db *Database) update(table dbTable, cmd *common.UpdateTableCommand) error
 		addr = block.nextBlock()
 	}
 
+	for _, r := range relocated {
+		if err := db.insertRecord(ctx, table, r.record); err != nil {
+			return err
+		}
+
+		updated = append(updated, [2]dbTuple{r.old, r.record.dbTuple})
+	}
+
+	updatedTuples := []dbTuple{}
+	for _, images := range updated {
+		updatedTuples = append(updatedTuples, images[1])
+	}
+
+	if err := db.checkUniques(ctx, table, updatedTuples); err != nil {
+		return err
+	}
+
+	for _, images := range updated {
+		result.add(images[0], images[1])
+		if err := db.rowChanged(ctx, table, images[0], images[1]); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -310,21 +614,55 @@ func dropCondition(tableName string, alias string, value int64) common.Expressio
 }
 
 func (db *Database) Drop(name string) error {
+	return db.DropContext(context.Background(), name)
+}
+
+func (db *Database) DropContext(ctx context.Context, name string) error {
+	return db.atomically(func() error {
+		return db.drop(ctx, name)
+	})
+}
+
+func (db *Database) drop(ctx context.Context, name string) error {
 	table, err := db.table(name)
 	if err != nil {
 		return err
 	}
 
-	if err := db.delete(db.sysTables(), dropCondition("SYS_TABLES", "TABLE_ID", int64(table.dbTableID))); err != nil {
+	if err := db.checkNoDependentViews(name); err != nil {
 		return err
 	}
 
-	if err := db.delete(db.sysColumns(), dropCondition("SYS_COLUMNS", "TABLE_ID", int64(table.dbTableID))); err != nil {
+	if err := db.delete(ctx, db.sysTables(), dropCondition("SYS_TABLES", "TABLE_ID", int64(table.dbTableID)), nil); err != nil {
+		return err
+	}
+
+	if err := db.delete(ctx, db.sysColumns(), dropCondition("SYS_COLUMNS", "TABLE_ID", int64(table.dbTableID)), nil); err != nil {
+		return err
+	}
+
+	if err := db.dropStatistics(ctx, *table); err != nil {
+		return err
+	}
+
+	if err := db.dropMaterializedView(ctx, *table); err != nil {
+		return err
+	}
+
+	if err := db.dropUniques(ctx, *table); err != nil {
+		return err
+	}
+
+	if err := db.dropChecks(ctx, *table); err != nil {
 		return err
 	}
 
 	// Delete all records block
 	for blockAddr := int64(table.firstRecordBlockAddr); blockAddr != nullBlockAddr; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		// Read block
 		block, err := db.readAt(blockAddr)
 		if err != nil {
@@ -339,54 +677,98 @@ func (db *Database) Drop(name string) error {
 		blockAddr = block.nextBlock()
 	}
 
+	db.recordChange(*table, ChangeDropTable, nil, nil)
+	delete(db.dbTriggers, table.name())
 	return db.deleteTable(table.name())
 }
 
-func (db *Database) Select(cmd *common.SelectTableCommand) ([]map[string]interface{}, error) {
-	table, err := db.table(cmd.TableName())
+func (db *Database) Select(cmd *common.SelectTableCommand, options ...SelectOption) (*ResultSet, error) {
+	return db.SelectContext(context.Background(), cmd, options...)
+}
+
+func (db *Database) SelectContext(ctx context.Context, cmd *common.SelectTableCommand, options ...SelectOption) (*ResultSet, error) {
+	query, err := newSelectQuery(cmd, options)
 	if err != nil {
 		return nil, err
 	}
 
-	result, err := db.tableSet(*table)
+	resetSubqueries(ctx, query.expressions()...)
+
+	plan, err := db.plan(query)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, joinCmd := range cmd.Joins() {
-		target, err := db.table(joinCmd.TargetTable())
-		if err != nil {
-			return nil, err
-		}
+	return db.resultSet(ctx, plan)
+}
 
-		targetSet, err := db.tableSet(*target)
-		if err != nil {
-			return nil, err
-		}
+func (db *Database) selectSet(ctx context.Context, query selectQuery) (dbSet, error) {
+	resetSubqueries(ctx, query.expressions()...)
 
-		result = join(result, targetSet, joinCmd.FilterCriteria())
+	plan, err := db.plan(query)
+	if err != nil {
+		return nil, err
+	}
+
+	result := dbSet{}
+	err = plan.run(ctx, db, func(tuple dbTuple) (bool, error) {
+		result = append(result, tuple)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (db *Database) CountDistinct(cmd *common.SelectTableCommand, column string) (int64, error) {
+	return db.CountDistinctContext(context.Background(), cmd, column)
+}
+
+/*
+CountDistinctContext returns the number of distinct non-NULL values of column among the rows
+selected by cmd, like COUNT(DISTINCT column) would.
+*/
+func (db *Database) CountDistinctContext(ctx context.Context, cmd *common.SelectTableCommand, column string) (int64, error) {
+	query, err := newSelectQuery(cmd, []SelectOption{Distinct()})
+	if err != nil {
+		return 0, err
 	}
 
-	if cmd.Condition() != nil {
-		result = selection(result, cmd.Condition())
+	if tableName, _ := splitIdentifier(column); tableName == "" {
+		column = concatTable(query.relation(), column)
 	}
+	query.columns = []projectedColumn{{name: column}}
 
-	selections := []string{}
-	for _, selector := range cmd.ProjectedColumns() {
-		selections = append(selections, selector.(*common.TableColumnSelector).ColumnName())
+	result, err := db.selectSet(ctx, query)
+	if err != nil {
+		return 0, err
 	}
 
-	result = projection(result, selections)
+	count := int64(0)
+	for i := range result {
+		if result[i][column] != nil {
+			count++
+		}
+	}
 
-	return result.stdSet(), nil
+	return count, nil
 }
 
 func newDatabase(dbInfo dbInfo, dbFile *os.File) *Database {
 	return &Database{
-		dbInfo:      dbInfo,
-		dbTableIDs:  map[string]dbInteger{},
-		dbSysTables: newSysTables(),
-		dbFile:      dbFile,
+		dbInfo:              dbInfo,
+		dbTableIDs:          map[string]dbInteger{},
+		dbSysTables:         newSysTables(),
+		dbViews:             map[string]*dbView{},
+		dbMaterializedViews: map[string]*dbMaterializedView{},
+		dbTriggers:          map[string][]dbTrigger{},
+		dbSequences:         map[string]*dbSequence{},
+		currentValues:       map[string]int64{},
+		dbFile:              dbFile,
+		changes:             newChangeFeed(),
+		heldTables:          map[string]TriggerEvent{},
 	}
 }
 
@@ -406,6 +788,33 @@ func (db Database) sysChars() dbTable {
 	return db.dbSysTables[3]
 }
 
+func (db Database) sysStatistics() dbTable {
+	return newStatisticsSysTable(dbInteger(db.statisticsRecordBlock))
+}
+
+/*
+createSysTable allocates the first record block of a system table that is created on
+first use and records its address in the database info through addr.
+*/
+func (db *Database) createSysTable(addr *int64, sysTable dbTable) error {
+	sysTableAddr, err := db.allocBlock()
+	if err != nil {
+		return err
+	}
+
+	sysTableRecordBlock, err := sysTable.newDBRecordBlock(db.blockSize)
+	if err != nil {
+		return err
+	}
+
+	if err := db.writeAt(sysTable.recordBlockBytes(sysTableRecordBlock), sysTableAddr); err != nil {
+		return err
+	}
+
+	*addr = sysTableAddr
+	return db.writeDbInfo()
+}
+
 func (db Database) name() string {
 	filename := filepath.Base(db.dbFile.Name())
 
@@ -437,6 +846,15 @@ func (db *Database) readDbInfo() error {
 		columns:              int64(binary.LittleEndian.Uint64(b[40:48])),
 		defaultNumerics:      int64(binary.LittleEndian.Uint64(b[48:56])),
 		defaultChars:         int64(binary.LittleEndian.Uint64(b[56:64])),
+
+		statisticsRecordBlock:        int64(binary.LittleEndian.Uint64(b[64:72])),
+		viewsRecordBlock:             int64(binary.LittleEndian.Uint64(b[72:80])),
+		materializedViewsRecordBlock: int64(binary.LittleEndian.Uint64(b[80:88])),
+		uniquesRecordBlock:           int64(binary.LittleEndian.Uint64(b[88:96])),
+		checksRecordBlock:            int64(binary.LittleEndian.Uint64(b[96:104])),
+		changeSequence:               int64(binary.LittleEndian.Uint64(b[104:112])),
+		sequencesRecordBlock:         int64(binary.LittleEndian.Uint64(b[112:120])),
+		formatVersion:                int64(binary.LittleEndian.Uint64(b[120:128])),
 	}
 
 	return nil
@@ -473,6 +891,23 @@ func (db Database) table(name string) (*dbTable, error) {
 	return nil, fmt.Errorf("Database `%s' does not contain table with ID %d", db.name(), dbTableID)
 }
 
+/*
+writableTable returns the table name for a write requested by the user. The rows of a
+materialized view only change when it is maintained, and a table can't be written by its
+own BEFORE UPDATE or BEFORE DELETE triggers, which run while one of its blocks is held.
+*/
+func (db *Database) writableTable(name string) (*dbTable, error) {
+	if _, ok := db.dbMaterializedViews[name]; ok {
+		return nil, fmt.Errorf("Table `%s' stores a materialized view and can't be written directly", name)
+	}
+
+	if event, ok := db.heldTables[name]; ok {
+		return nil, fmt.Errorf("Table `%s' can't be written by its own %s triggers", name, event)
+	}
+
+	return db.table(name)
+}
+
 func (db *Database) addTable(dbTable dbTable) error {
 	if dbTable, _ := db.table(dbTable.name()); dbTable != nil {
 		return fmt.Errorf("Duplicate table `%s' in Database `%s'", dbTable.name(), db.name())
@@ -520,6 +955,12 @@ func (db Database) writeAt(b []byte, addr int64) error {
 		return err
 	}
 
+	if db.journal != nil {
+		if err := db.journal.save(db, addr); err != nil {
+			return err
+		}
+	}
+
 	_, err = db.dbFile.WriteAt(append(b, make([]byte, blockPaddingLen)...), blockOffset)
 	return err
 }
@@ -547,7 +988,7 @@ func (db *Database) allocBlock() (int64, error) {
 		}
 
 		db.dbInfo.blocks++
-		return addr, nil
+		return addr, db.writeDbInfo()
 	}
 
 	addr := db.dbInfo.availableBlocksFront
@@ -558,7 +999,7 @@ func (db *Database) allocBlock() (int64, error) {
 
 	db.dbInfo.availableBlocksFront = block.nextBlock()
 	db.dbInfo.availableBlocks--
-	return addr, nil
+	return addr, db.writeDbInfo()
 }
 
 func (db *Database) freeBlock(addr int64) error {
@@ -574,28 +1015,89 @@ func (db *Database) freeBlock(addr int64) error {
 
 	db.dbInfo.availableBlocksFront = addr
 	db.dbInfo.availableBlocks++
-	return nil
+	return db.writeDbInfo()
 }
 
 func (db Database) tableSet(table dbTable) (set dbSet, err error) {
-	for i := int64(table.firstRecordBlockAddr); i != nullBlockAddr; {
-		block, err := db.readAt(i)
+	err = db.scanTable(context.Background(), table, func(tuple dbTuple) (bool, error) {
+		set = append(set, tuple)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return set, nil
+}
+
+/*
+scanTable calls fn with every tuple stored in the table's record blocks, in block order.
+The scan stops without reading further blocks as soon as fn returns false or an error.
+*/
+func (db Database) scanTable(ctx context.Context, table dbTable, fn func(dbTuple) (bool, error)) error {
+	_, err := db.scanTuples(ctx, table, nil, fn)
+	return err
+}
+
+/*
+scanRecordBlocks calls fn with each record block of the table until fn returns false or an
+error. The context is checked before reading every block.
+*/
+func (db Database) scanRecordBlocks(ctx context.Context, table dbTable, fn func(int64, dbRecordBlock) (bool, error)) error {
+	for addr := int64(table.firstRecordBlockAddr); addr != nullBlockAddr; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		block, err := db.readAt(addr)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		recordBlock := table.loadRecordBlockBytes(block)
-		records := recordBlock.dbRecords
-		for i := range records {
-			if !records[i].isFree() {
-				set = append(set, records[i].dbTuple)
-			}
+		if more, err := fn(addr, table.loadRecordBlockBytes(block)); err != nil || !more {
+			return err
 		}
 
-		i = block.nextBlock()
+		addr = block.nextBlock()
 	}
 
-	return set, nil
+	return nil
+}
+
+// nextBlockAddr reads only the next block pointer of the block at addr.
+func (db Database) nextBlockAddr(addr int64) (int64, error) {
+	blockOffset, err := db.blockOffset(addr)
+	if err != nil {
+		return 0, err
+	}
+
+	b := make(dbBlock, 8)
+	if _, err := db.dbFile.ReadAt(b, blockOffset); err != nil {
+		return 0, err
+	}
+
+	return b.nextBlock(), nil
+}
+
+/*
+estimateRows returns the row count gathered by the last Analyze of the table or, if it was
+never analyzed, the number of record slots in its record blocks.
+*/
+func (db Database) estimateRows(table dbTable) (int64, error) {
+	if table.statistics != nil {
+		return table.statistics.rows, nil
+	}
+
+	blocks := int64(0)
+	for addr := int64(table.firstRecordBlockAddr); addr != nullBlockAddr; blocks++ {
+		next, err := db.nextBlockAddr(addr)
+		if err != nil {
+			return 0, err
+		}
+		addr = next
+	}
+
+	return blocks * int64(table.recordsPerBlock(db.blockSize)), nil
 }
 
 func (db *Database) loadTables() error {
@@ -656,8 +1158,14 @@ func (db *Database) loadTables() error {
 /*
 CommandFactory creates instances of common.Command according to command object received
 as parameter. Once the command is run, execution is moved to the callback function received as parameter.
+Options like WithTimeout apply to each execution of the command.
 */
-func (db *Database) CommandFactory(cmd interface{}, cb func(interface{}, error)) (command common.Command) {
+func (db *Database) CommandFactory(cmd interface{}, cb func(interface{}, error), options ...CommandOption) (command common.Command) {
+	settings := commandSettings{}
+	for _, option := range options {
+		option(&settings)
+	}
+
 	switch cmd := cmd.(type) {
 	case *common.CreateTableCommand:
 		command = common.NewCommand(
@@ -669,7 +1177,10 @@ func (db *Database) CommandFactory(cmd interface{}, cb func(interface{}, error))
 						cb(nil, errors.New(r.(string)))
 					}
 				}()
-				cb(nil, db.NewTable(cmd.TableName(), cmd.TableColumnDefiners()))
+
+				ctx, cancel := settings.context()
+				defer cancel()
+				cb(nil, db.NewTableContext(ctx, cmd.TableName(), cmd.TableColumnDefiners()))
 			},
 		)
 	case *common.InsertCommand:
@@ -682,7 +1193,90 @@ func (db *Database) CommandFactory(cmd interface{}, cb func(interface{}, error))
 						cb(nil, errors.New(r.(string)))
 					}
 				}()
-				cb(nil, db.Insert(cmd.TableName(), cmd.Values()))
+
+				ctx, cancel := settings.context()
+				defer cancel()
+				cb(nil, db.InsertContext(ctx, cmd.TableName(), cmd.Values()))
+			},
+		)
+	case *InsertSelectCommand:
+		command = common.NewCommand(
+			cmd,
+			common.Insert,
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						cb(nil, errors.New(r.(string)))
+					}
+				}()
+
+				ctx, cancel := settings.context()
+				defer cancel()
+				cb(nil, db.InsertSelectContext(ctx, cmd.TableName(), cmd.Columns(), cmd.SelectCommand(), settings.selectOptions...))
+			},
+		)
+	case *TruncateTableCommand:
+		command = common.NewCommand(
+			cmd,
+			common.Delete,
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						cb(nil, errors.New(r.(string)))
+					}
+				}()
+
+				ctx, cancel := settings.context()
+				defer cancel()
+				cb(nil, db.TruncateContext(ctx, cmd.TableName(), cmd.Options()...))
+			},
+		)
+	case *RenameTableCommand:
+		command = common.NewCommand(
+			cmd,
+			common.Update,
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						cb(nil, errors.New(r.(string)))
+					}
+				}()
+
+				ctx, cancel := settings.context()
+				defer cancel()
+				cb(nil, db.RenameTableContext(ctx, cmd.OldName(), cmd.NewName()))
+			},
+		)
+	case *CreateSequenceCommand:
+		command = common.NewCommand(
+			cmd,
+			common.Create,
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						cb(nil, errors.New(r.(string)))
+					}
+				}()
+
+				ctx, cancel := settings.context()
+				defer cancel()
+				cb(nil, db.CreateSequenceContext(ctx, cmd.SequenceName(), cmd.Options()...))
+			},
+		)
+	case *DropSequenceCommand:
+		command = common.NewCommand(
+			cmd,
+			common.Drop,
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						cb(nil, errors.New(r.(string)))
+					}
+				}()
+
+				ctx, cancel := settings.context()
+				defer cancel()
+				cb(nil, db.DropSequenceContext(ctx, cmd.SequenceName()))
 			},
 		)
 	case *common.UpdateTableCommand:
@@ -695,7 +1289,15 @@ func (db *Database) CommandFactory(cmd interface{}, cb func(interface{}, error))
 						cb(nil, errors.New(r.(string)))
 					}
 				}()
-				cb(nil, db.Update(cmd))
+
+				ctx, cancel := settings.context()
+				defer cancel()
+				result, err := db.UpdateContext(ctx, cmd, settings.modifyOptions()...)
+				if err != nil {
+					cb(nil, err)
+					return
+				}
+				cb(result, nil)
 			},
 		)
 	case *common.DeleteCommand:
@@ -708,7 +1310,15 @@ func (db *Database) CommandFactory(cmd interface{}, cb func(interface{}, error))
 						cb(nil, errors.New(r.(string)))
 					}
 				}()
-				cb(nil, db.Delete(cmd.TableName(), cmd.Condition()))
+
+				ctx, cancel := settings.context()
+				defer cancel()
+				result, err := db.DeleteContext(ctx, cmd.TableName(), cmd.Condition(), settings.modifyOptions()...)
+				if err != nil {
+					cb(nil, err)
+					return
+				}
+				cb(result, nil)
 			},
 		)
 	case *common.DropCommand:
@@ -721,7 +1331,10 @@ func (db *Database) CommandFactory(cmd interface{}, cb func(interface{}, error))
 						cb(nil, errors.New(r.(string)))
 					}
 				}()
-				cb(nil, db.Drop(cmd.TableName()))
+
+				ctx, cancel := settings.context()
+				defer cancel()
+				cb(nil, db.DropContext(ctx, cmd.TableName()))
 			},
 		)
 	case *common.SelectTableCommand:
@@ -734,7 +1347,80 @@ func (db *Database) CommandFactory(cmd interface{}, cb func(interface{}, error))
 						cb(nil, errors.New(r.(string)))
 					}
 				}()
-				cb(db.Select(cmd))
+
+				ctx, cancel := settings.context()
+				defer cancel()
+				result, err := db.SelectContext(ctx, cmd, settings.selectOptions...)
+				if err != nil {
+					cb(nil, err)
+					return
+				}
+				cb(result, nil)
+			},
+		)
+	case *ExplainCommand:
+		command = common.NewCommand(
+			cmd,
+			common.Select,
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						cb(nil, errors.New(r.(string)))
+					}
+				}()
+
+				ctx, cancel := settings.context()
+				defer cancel()
+
+				var plan *PlanNode
+				var err error
+				if cmd.Analyze() {
+					plan, err = db.ExplainAnalyzeContext(ctx, cmd.SelectCommand(), settings.selectOptions...)
+				} else {
+					plan, err = db.ExplainContext(ctx, cmd.SelectCommand(), settings.selectOptions...)
+				}
+
+				if err != nil {
+					cb(nil, err)
+					return
+				}
+				cb(plan, nil)
+			},
+		)
+	case *CreateViewCommand:
+		command = common.NewCommand(
+			cmd,
+			common.Create,
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						cb(nil, errors.New(r.(string)))
+					}
+				}()
+
+				ctx, cancel := settings.context()
+				defer cancel()
+				if cmd.Materialized() {
+					cb(nil, db.CreateMaterializedViewContext(ctx, cmd.ViewName(), cmd.SelectCommand(), settings.selectOptions...))
+				} else {
+					cb(nil, db.CreateViewContext(ctx, cmd.ViewName(), cmd.SelectCommand(), settings.selectOptions...))
+				}
+			},
+		)
+	case *DropViewCommand:
+		command = common.NewCommand(
+			cmd,
+			common.Drop,
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						cb(nil, errors.New(r.(string)))
+					}
+				}()
+
+				ctx, cancel := settings.context()
+				defer cancel()
+				cb(nil, db.DropViewContext(ctx, cmd.ViewName()))
 			},
 		)
 	default:
@@ -744,6 +1430,50 @@ func (db *Database) CommandFactory(cmd interface{}, cb func(interface{}, error))
 	return command
 }
 
+// CommandOption configures the commands created by CommandFactory.
+type CommandOption func(*commandSettings)
+
+type commandSettings struct {
+	timeout       time.Duration
+	returning     bool
+	selectOptions []SelectOption
+}
+
+// WithTimeout cancels a command that runs longer than timeout, rolling back its partial writes.
+func WithTimeout(timeout time.Duration) CommandOption {
+	return func(s *commandSettings) {
+		s.timeout = timeout
+	}
+}
+
+// WithReturning makes update and delete commands pass the rows they change to their callback.
+func WithReturning() CommandOption {
+	return func(s *commandSettings) {
+		s.returning = true
+	}
+}
+
+// WithSelectOptions applies options to the select command of a command, like As and JoinAs to alias its tables.
+func WithSelectOptions(options ...SelectOption) CommandOption {
+	return func(s *commandSettings) {
+		s.selectOptions = append(s.selectOptions, options...)
+	}
+}
+
+func (s commandSettings) modifyOptions() []ModifyOption {
+	if s.returning {
+		return []ModifyOption{Returning()}
+	}
+	return nil
+}
+
+func (s commandSettings) context() (context.Context, context.CancelFunc) {
+	if s.timeout > 0 {
+		return context.WithTimeout(context.Background(), s.timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
 func splitIdentifier(identifier string) (tableName string, columnName string) {
 	names := strings.Split(identifier, ".")
 	if len(names) != 2 {
@@ -1,7 +1,10 @@
 package data
 
 import (
+	"context"
 	"fmt"
+	"math"
+	"strings"
 	"testing"
 
 	"github.com/modest-sql/common"
@@ -60,3 +63,2403 @@ func TestSystemBlockSize(t *testing.T) {
 
 	fmt.Println(tablesSet[5])
 }
+
+func TestSelectLimitOffset(t *testing.T) {
+	db, err := NewDatabase("limit.db", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	columns := []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ID", nil, false, false, true, false),
+	}
+
+	if err := db.NewTable("NUMBERS", columns); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 400; i++ {
+		if err := db.Insert("NUMBERS", map[string]interface{}{"ID": int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	query := selectQuery{table: "NUMBERS", columns: []projectedColumn{{name: "*"}}, limit: 5, offset: 40}
+	result, err := db.selectSet(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result) != 5 {
+		t.Fatalf("Expected 5 rows, got %d", len(result))
+	}
+
+	for i := range result {
+		if id := result[i]["NUMBERS.ID"]; id != dbInteger(40+i) {
+			t.Fatalf("Expected row %d to have ID %d, got %v", i, 40+i, id)
+		}
+	}
+
+	// The scan stops once the limit is reached instead of reading every block.
+	table, err := db.table("NUMBERS")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	directory, err := db.blockDirectory(context.Background(), *table)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := db.plan(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan = instrumentPlan(plan)
+	if err := plan.run(context.Background(), db, func(dbTuple) (bool, error) { return true, nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	scan := describePlan(plan)
+	for len(scan.Children) > 0 {
+		scan = scan.Children[0]
+	}
+
+	if len(directory) < 4 || scan.BlocksRead == 0 || scan.BlocksRead >= int64(len(directory))/2 {
+		t.Fatalf("Expected the scan to read few of the %d blocks, got %d", len(directory), scan.BlocksRead)
+	}
+
+	query.limit = 0
+	if result, err = db.selectSet(context.Background(), query); err != nil {
+		t.Fatal(err)
+	} else if len(result) != 0 {
+		t.Fatalf("Expected no rows, got %d", len(result))
+	}
+}
+
+func TestDistinct(t *testing.T) {
+	db, err := NewDatabase("distinct.db", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	columns := []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ID", nil, false, false, true, false),
+		common.NewCharTableColumn("NAME", nil, true, false, false, false, 10),
+	}
+
+	if err := db.NewTable("PEOPLE", columns); err != nil {
+		t.Fatal(err)
+	}
+
+	names := []interface{}{"ANA", "LUIS", "ANA", nil, "MARIA", nil, "LUIS"}
+	for i, name := range names {
+		if err := db.Insert("PEOPLE", map[string]interface{}{"ID": int64(i), "NAME": name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	query := selectQuery{table: "PEOPLE", columns: []projectedColumn{{name: "PEOPLE.NAME"}}, distinct: true, limit: noLimit}
+	result, err := db.selectSet(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result) != 4 {
+		t.Fatalf("Expected 4 distinct names, got %d", len(result))
+	}
+
+	if tupleKey(dbTuple{"A": newChar(10, "ANA")}) != tupleKey(dbTuple{"A": newChar(20, "ANA")}) {
+		t.Fatal("Expected CHAR padding to be ignored")
+	}
+
+	filter := newDistinctFilter(2)
+	defer filter.close()
+
+	unique := dbSet{}
+	for i := 0; i < 50; i++ {
+		tuple := dbTuple{"N": dbInteger(i % 10)}
+		if ok, err := filter.add(tuple); err != nil {
+			t.Fatal(err)
+		} else if ok {
+			unique = append(unique, tuple)
+		}
+	}
+
+	err = filter.flush(func(tuple dbTuple) (bool, error) {
+		unique = append(unique, tuple)
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(unique) != 10 {
+		t.Fatalf("Expected 10 distinct tuples after spilling, got %d", len(unique))
+	}
+}
+
+func TestComputedProjection(t *testing.T) {
+	db, err := NewDatabase("computed.db", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	columns := []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ID", nil, false, false, true, false),
+		common.NewCharTableColumn("NAME", nil, true, false, false, false, 10),
+	}
+
+	if err := db.NewTable("PEOPLE", columns); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Insert("PEOPLE", map[string]interface{}{"ID": int64(21), "NAME": "ana"}); err != nil {
+		t.Fatal(err)
+	}
+
+	upper, err := NewFunctionExpression("upper", NewColumnExpression("NAME"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	double := NewArithmeticExpression(Multiplication, NewColumnExpression("PEOPLE.ID"), NewLiteralExpression(int64(2)))
+	label := NewConcatExpression(upper, NewLiteralExpression("!"))
+
+	query := selectQuery{table: "PEOPLE", limit: noLimit, columns: []projectedColumn{
+		{name: "DOUBLE", expression: double},
+		{name: "LABEL", expression: label},
+	}}
+
+	result, err := db.selectSet(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result) != 1 || len(result[0]) != 2 {
+		t.Fatalf("Expected one row with two columns, got %v", result)
+	}
+
+	row := result[0].stdMap()
+	if row["DOUBLE"] != int64(42) || row["LABEL"] != "ANA!" {
+		t.Fatalf("Unexpected computed values %v", row)
+	}
+
+	query.columns = []projectedColumn{{name: "BAD", expression: NewArithmeticExpression(Division, double, NewLiteralExpression(int64(0)))}}
+	if _, err := db.selectSet(context.Background(), query); err == nil {
+		t.Fatal("Expected division by zero error")
+	}
+}
+
+func TestPlannerPushdownAndJoinOrder(t *testing.T) {
+	db, err := NewDatabase("planner.db", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	employees := []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ID", nil, false, false, true, false),
+		common.NewIntegerTableColumn("DEPARTMENT_ID", nil, false, false, false, true),
+		common.NewIntegerTableColumn("SALARY", nil, false, false, false, false),
+	}
+
+	departments := []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ID", nil, false, false, true, false),
+		common.NewCharTableColumn("NAME", nil, false, false, false, false, 10),
+	}
+
+	if err := db.NewTable("EMPLOYEES", employees); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.NewTable("DEPARTMENTS", departments); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := db.Insert("DEPARTMENTS", map[string]interface{}{"ID": int64(i), "NAME": fmt.Sprintf("D%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 200; i++ {
+		values := map[string]interface{}{"ID": int64(i), "DEPARTMENT_ID": int64(i % 3), "SALARY": int64(i * 10)}
+		if err := db.Insert("EMPLOYEES", values); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	condition := NewAndExpression(
+		NewComparisonExpression(Greater, NewColumnExpression("SALARY"), NewLiteralExpression(int64(1500))),
+		NewComparisonExpression(Equal, NewColumnExpression("DEPARTMENTS.NAME"), NewLiteralExpression("D1")),
+	)
+
+	query := selectQuery{
+		table:     "EMPLOYEES",
+		joins:     []joinClause{{table: "DEPARTMENTS", criteria: NewComparisonExpression(Equal, NewColumnExpression("EMPLOYEES.DEPARTMENT_ID"), NewColumnExpression("DEPARTMENTS.ID"))}},
+		condition: condition,
+		columns:   []projectedColumn{{name: "EMPLOYEES.ID"}},
+		limit:     noLimit,
+	}
+
+	plan, err := db.plan(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	join, ok := plan.(*projectNode).input.(*joinNode)
+	if !ok {
+		t.Fatalf("Expected a join below the projection, got %T", plan.(*projectNode).input)
+	}
+
+	if scan := join.left.(*scanNode); scan.table.name() != "DEPARTMENTS" || len(scan.filters) != 1 {
+		t.Fatalf("Expected the filtered DEPARTMENTS scan to drive the join, got %s", scan.table.name())
+	}
+
+	if scan := join.right.(*scanNode); len(scan.filters) != 1 || len(join.leftKeys) != 1 || len(join.criteria) != 0 {
+		t.Fatal("Expected the salary filter on the EMPLOYEES scan and a hashed equi-join")
+	}
+
+	result, err := db.selectSet(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Employees with SALARY > 1500 have ID >= 151; those in D1 have ID % 3 == 1.
+	expected := 0
+	for i := 151; i < 200; i++ {
+		if i%3 == 1 {
+			expected++
+		}
+	}
+
+	if len(result) != expected {
+		t.Fatalf("Expected %d rows, got %d", expected, len(result))
+	}
+
+	for i := range result {
+		if id := result[i]["EMPLOYEES.ID"].(dbInteger); id < 151 || id%3 != 1 {
+			t.Fatalf("Unexpected employee %d", id)
+		}
+	}
+}
+
+func TestExplainAnalyze(t *testing.T) {
+	db, err := NewDatabase("explain.db", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	columns := []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ID", nil, false, false, true, false),
+	}
+
+	if err := db.NewTable("NUMBERS", columns); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 200; i++ {
+		if err := db.Insert("NUMBERS", map[string]interface{}{"ID": int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	query := selectQuery{
+		table:     "NUMBERS",
+		condition: NewComparisonExpression(GreaterOrEqual, NewColumnExpression("ID"), NewLiteralExpression(int64(10))),
+		columns:   []projectedColumn{{name: "*"}},
+		limit:     5,
+	}
+
+	plan, err := db.plan(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan = instrumentPlan(plan)
+	if err := plan.run(context.Background(), db, func(dbTuple) (bool, error) { return true, nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	description := describePlan(plan)
+	if description.Operator != "Limit" || description.Rows != 5 {
+		t.Fatalf("Expected a Limit producing 5 rows, got\n%s", description)
+	}
+
+	scan := description.Children[0].Children[0]
+	if scan.Operator != "Seq Scan" || scan.Detail != "NUMBERS filter: ID >= 10" {
+		t.Fatalf("Unexpected scan operator\n%s", description)
+	}
+
+	if scan.BlocksRead != 1 || scan.Rows != 5 {
+		t.Fatalf("Expected the scan to stop after one block and 5 rows\n%s", description)
+	}
+}
+
+func TestSubqueries(t *testing.T) {
+	db, err := NewDatabase("subqueries.db", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	customers := []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ID", nil, false, false, true, false),
+		common.NewIntegerTableColumn("VIP", nil, false, false, false, false),
+	}
+
+	orders := []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("CUSTOMER_ID", nil, false, false, false, true),
+		common.NewIntegerTableColumn("TOTAL", nil, false, false, false, false),
+	}
+
+	if err := db.NewTable("CUSTOMERS", customers); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.NewTable("ORDERS", orders); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := db.Insert("CUSTOMERS", map[string]interface{}{"ID": int64(i), "VIP": int64(i % 2)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, order := range [][2]int64{{1, 10}, {1, 20}, {3, 30}, {4, 40}} {
+		if err := db.Insert("ORDERS", map[string]interface{}{"CUSTOMER_ID": order[0], "TOTAL": order[1]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all := []projectedColumn{{name: "*"}}
+
+	// Customers with an order over 15, correlated on the outer customer.
+	exists, err := db.subquery(existsSubquery, nil, selectQuery{
+		table: "ORDERS",
+		condition: NewAndExpression(
+			NewComparisonExpression(Equal, NewColumnExpression("ORDERS.CUSTOMER_ID"), NewColumnExpression("CUSTOMERS.ID")),
+			NewComparisonExpression(Greater, NewColumnExpression("ORDERS.TOTAL"), NewLiteralExpression(int64(15))),
+		),
+		columns: all,
+		limit:   noLimit,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !exists.correlated() {
+		t.Fatal("Expected the EXISTS subquery to be correlated")
+	}
+
+	result, err := db.selectSet(context.Background(), selectQuery{table: "CUSTOMERS", condition: exists, columns: all, limit: noLimit})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result) != 3 {
+		t.Fatalf("Expected 3 customers with large orders, got %d", len(result))
+	}
+
+	// Orders of VIP customers, uncorrelated.
+	in, err := db.subquery(inSubquery, NewColumnExpression("CUSTOMER_ID"), selectQuery{
+		table:     "CUSTOMERS",
+		condition: NewComparisonExpression(Equal, NewColumnExpression("VIP"), NewLiteralExpression(int64(1))),
+		columns:   []projectedColumn{{name: "CUSTOMERS.ID"}},
+		limit:     noLimit,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if in.correlated() {
+		t.Fatal("Expected the IN subquery not to be correlated")
+	}
+
+	if result, err = db.selectSet(context.Background(), selectQuery{table: "ORDERS", condition: in, columns: all, limit: noLimit}); err != nil {
+		t.Fatal(err)
+	} else if len(result) != 3 {
+		t.Fatalf("Expected 3 orders of VIP customers, got %d", len(result))
+	}
+
+	scalar, err := db.subquery(scalarSubquery, nil, selectQuery{table: "ORDERS", columns: []projectedColumn{{name: "ORDERS.TOTAL"}}, limit: noLimit})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value := scalar.Evaluate(nil); value == nil {
+		t.Fatal("Expected an error for a scalar subquery returning many rows")
+	} else if _, ok := value.(error); !ok {
+		t.Fatalf("Expected an error for a scalar subquery returning many rows, got %v", value)
+	}
+
+	// A subquery runs with the context of its statement.
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	resetSubqueries(cancelled, scalar)
+	if value := scalar.Evaluate(nil); value != context.Canceled {
+		t.Fatalf("Expected a subquery of a cancelled statement to fail with %v, got %v", context.Canceled, value)
+	}
+
+	ordersTable, err := db.table("ORDERS")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.delete(context.Background(), *ordersTable, NewNotExpression(in), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	orderSet, err := db.tableSet(*ordersTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(orderSet) != 3 {
+		t.Fatalf("Expected only orders of VIP customers to remain, got %d", len(orderSet))
+	}
+}
+
+func TestSetOperations(t *testing.T) {
+	db, err := NewDatabase("setops.db", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"A", "B"} {
+		columns := []common.TableColumnDefiner{
+			common.NewIntegerTableColumn("N", nil, false, false, false, false),
+			common.NewCharTableColumn("S", nil, true, false, false, false, 8),
+		}
+
+		if err := db.NewTable(name, columns); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, n := range []int64{1, 2, 2, 3} {
+		if err := db.Insert("A", map[string]interface{}{"N": n}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, n := range []int64{2, 3, 4} {
+		if err := db.Insert("B", map[string]interface{}{"N": n}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	left := selectQuery{table: "A", columns: []projectedColumn{{name: "A.N"}}, limit: noLimit}
+	right := selectQuery{table: "B", columns: []projectedColumn{{name: "B.N"}}, limit: noLimit}
+
+	expected := map[SetOperator]int{Union: 4, UnionAll: 7, Intersect: 2, Except: 1}
+	for operator, rows := range expected {
+		plan, err := db.planSetOperation(context.Background(), operator, left, right)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result := dbSet{}
+		err = plan.run(context.Background(), db, func(tuple dbTuple) (bool, error) {
+			result = append(result, tuple)
+			return true, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(result) != rows {
+			t.Fatalf("Expected %s to produce %d rows, got %d", setOperatorNames[operator], rows, len(result))
+		}
+
+		for i := range result {
+			if _, ok := result[i]["A.N"]; !ok || len(result[i]) != 1 {
+				t.Fatalf("Expected rows named after the left columns, got %v", result[i])
+			}
+		}
+	}
+
+	if err := db.NewTable("C", []common.TableColumnDefiner{common.NewFloatTableColumn("F", nil, false, false, false, false)}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range []float64{1, 2.5} {
+		if err := db.Insert("C", map[string]interface{}{"F": f}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	floats := selectQuery{table: "C", columns: []projectedColumn{{name: "C.F"}}, limit: noLimit}
+	expected = map[SetOperator]int{Union: 4, Intersect: 1, Except: 2}
+	for operator, rows := range expected {
+		result, err := db.planSetOperation(context.Background(), operator, left, floats)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		count := 0
+		err = result.run(context.Background(), db, func(tuple dbTuple) (bool, error) {
+			if _, ok := tuple["A.N"].(dbFloat); !ok {
+				t.Fatalf("Expected INTEGER values widened to FLOAT, got %v", tuple)
+			}
+			count++
+			return true, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if count != rows {
+			t.Fatalf("Expected %s of 1 and 1.0 to produce %d rows, got %d", setOperatorNames[operator], rows, count)
+		}
+	}
+
+	right.columns = []projectedColumn{{name: "B.S"}}
+	if _, err := db.planSetOperation(context.Background(), Union, left, right); err == nil {
+		t.Fatal("Expected INTEGER and CHAR columns to be incompatible")
+	}
+
+	right.columns = []projectedColumn{{name: "*"}}
+	if _, err := db.planSetOperation(context.Background(), Union, left, right); err == nil {
+		t.Fatal("Expected a column count mismatch")
+	}
+}
+
+func TestResultSet(t *testing.T) {
+	db, err := NewDatabase("resultset.db", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	columns := []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ID", nil, false, false, true, false),
+		common.NewCharTableColumn("NAME", nil, true, false, false, false, 10),
+	}
+
+	if err := db.NewTable("PEOPLE", columns); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Insert("PEOPLE", map[string]interface{}{"ID": int64(1), "NAME": "ANA"}); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := db.plan(selectQuery{table: "PEOPLE", limit: noLimit, columns: []projectedColumn{
+		{name: "*"},
+		{name: "NEXT_ID", expression: NewArithmeticExpression(Addition, NewColumnExpression("ID"), NewLiteralExpression(int64(1)))},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := db.resultSet(context.Background(), plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ResultColumn{
+		{ColumnName: "PEOPLE.ID", TableName: "PEOPLE", ColumnType: dbIntegerTypeID, ColumnSize: dbIntegerSize, Nullable: false},
+		{ColumnName: "PEOPLE.NAME", TableName: "PEOPLE", ColumnType: dbCharTypeID, ColumnSize: 10, Nullable: true},
+		{ColumnName: "NEXT_ID", ColumnType: dbIntegerTypeID, ColumnSize: dbIntegerSize, Nullable: true},
+	}
+
+	if len(rs.Columns) != len(expected) {
+		t.Fatalf("Expected %d columns, got %v", len(expected), rs.Columns)
+	}
+
+	for i := range expected {
+		if rs.Columns[i] != expected[i] {
+			t.Fatalf("Expected column %d to be %v, got %v", i, expected[i], rs.Columns[i])
+		}
+	}
+
+	if len(rs.Rows) != 1 || rs.Rows[0][0] != int64(1) || rs.Rows[0][1] != "ANA" || rs.Rows[0][2] != int64(2) {
+		t.Fatalf("Unexpected rows %v", rs.Rows)
+	}
+
+	if maps := rs.Maps(); maps[0]["PEOPLE.NAME"] != "ANA" || maps[0]["NEXT_ID"] != int64(2) {
+		t.Fatalf("Unexpected map form %v", maps)
+	}
+
+	if err := db.NewTable("VISITS", []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("PERSON_ID", nil, false, false, false, false),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 200; i++ {
+		if err := db.Insert("VISITS", map[string]interface{}{"PERSON_ID": int64(1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The planner joins the smaller table first, but `*' keeps the order the tables are written in.
+	visits := selectQuery{
+		table:   "VISITS",
+		joins:   []joinClause{{table: "PEOPLE", criteria: NewComparisonExpression(Equal, NewColumnExpression("VISITS.PERSON_ID"), NewColumnExpression("PEOPLE.ID"))}},
+		columns: []projectedColumn{{name: "*"}},
+		limit:   noLimit,
+	}
+
+	names := func() []string {
+		plan, err := db.plan(visits)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rs, err := db.resultSet(context.Background(), plan)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		names := []string{}
+		for _, column := range rs.Columns {
+			names = append(names, column.ColumnName)
+		}
+		return names
+	}
+
+	before := names()
+	for _, name := range []string{"PEOPLE", "VISITS"} {
+		if err := db.Analyze(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if after := names(); strings.Join(before, ",") != "VISITS.PERSON_ID,PEOPLE.ID,PEOPLE.NAME" || strings.Join(after, ",") != strings.Join(before, ",") {
+		t.Fatalf("Expected the columns in the order the tables are written before and after Analyze, got %v and %v", before, after)
+	}
+}
+
+// cancelAfter is a condition that holds for every row and cancels its context after n evaluations.
+type cancelAfter struct {
+	n      int
+	cancel context.CancelFunc
+}
+
+func (c *cancelAfter) Evaluate(symbols map[string]interface{}) interface{} {
+	if c.n--; c.n == 0 {
+		c.cancel()
+	}
+	return true
+}
+
+func TestCancellationRollsBack(t *testing.T) {
+	db, err := NewDatabase("cancel.db", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	columns := []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ID", nil, false, false, true, false),
+	}
+
+	if err := db.NewTable("NUMBERS", columns); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		if err := db.Insert("NUMBERS", map[string]interface{}{"ID": int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	plan, err := db.plan(selectQuery{table: "NUMBERS", columns: []projectedColumn{{name: "*"}}, limit: noLimit})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.resultSet(ctx, plan); err != context.Canceled {
+		t.Fatalf("Expected %v, got %v", context.Canceled, err)
+	}
+
+	if err := db.InsertContext(ctx, "NUMBERS", map[string]interface{}{"ID": int64(100)}); err != context.Canceled {
+		t.Fatalf("Expected %v, got %v", context.Canceled, err)
+	}
+
+	// The condition cancels the delete while it processes the second record block.
+	ctx, cancel = context.WithCancel(context.Background())
+	table, _ := db.table("NUMBERS")
+	condition := &cancelAfter{n: table.recordsPerBlock(db.blockSize) + 1, cancel: cancel}
+
+	if _, err := db.DeleteContext(ctx, "NUMBERS", condition); err != context.Canceled {
+		t.Fatalf("Expected %v, got %v", context.Canceled, err)
+	}
+
+	set, err := db.tableSet(*table)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(set) != 100 {
+		t.Fatalf("Expected the cancelled delete to be rolled back, got %d rows", len(set))
+	}
+}
+
+func TestParallelScan(t *testing.T) {
+	db, err := NewDatabase("parallel.db", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	columns := []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ID", nil, false, false, true, false),
+	}
+
+	if err := db.NewTable("NUMBERS", columns); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 500; i++ {
+		if err := db.Insert("NUMBERS", map[string]interface{}{"ID": int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.SetParallelism(0); err == nil {
+		t.Fatal("Expected a parallelism of 0 to be rejected")
+	}
+
+	if err := db.SetParallelism(4); err != nil {
+		t.Fatal(err)
+	}
+
+	condition := NewComparisonExpression(GreaterOrEqual, NewColumnExpression("ID"), NewLiteralExpression(int64(250)))
+	query := selectQuery{table: "NUMBERS", columns: []projectedColumn{{name: "*"}}, condition: condition, limit: 100, offset: 10}
+	result, err := db.selectSet(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result) != 100 {
+		t.Fatalf("Expected 100 rows, got %d", len(result))
+	}
+
+	for i := range result {
+		if id := result[i]["NUMBERS.ID"]; id != dbInteger(260+i) {
+			t.Fatalf("Expected row %d to have ID %d, got %v", i, 260+i, id)
+		}
+	}
+
+	// A worker that panics fails the scan instead of the process.
+	table, err := db.table("NUMBERS")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filters := []common.Expression{panicOn{id: 300}}
+	if _, err := db.scanTuples(context.Background(), *table, filters, func(dbTuple) (bool, error) { return true, nil }); err == nil {
+		t.Fatal("Expected the panic of a worker to fail the scan")
+	}
+}
+
+// panicOn is a condition that holds for every row but panics on the row with the given ID.
+type panicOn struct {
+	id int64
+}
+
+func (p panicOn) Evaluate(symbols map[string]interface{}) interface{} {
+	if symbols["NUMBERS.ID"] == p.id {
+		panic("corrupt row")
+	}
+	return true
+}
+
+func TestAnalyze(t *testing.T) {
+	db, err := NewDatabase("analyze.db", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	columns := []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ID", nil, false, false, true, false),
+		common.NewCharTableColumn("NAME", nil, true, false, false, false, 10),
+	}
+
+	if err := db.NewTable("PEOPLE", columns); err != nil {
+		t.Fatal(err)
+	}
+
+	names := []interface{}{"MARIA", nil, "ANA", "LUIS", "ANA", nil, "LUIS", "ANA"}
+	for i := 0; i < 1200; i++ {
+		if err := db.Insert("PEOPLE", map[string]interface{}{"ID": int64(i), "NAME": names[i%len(names)]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if db.AllTables()[0].Statistics != nil {
+		t.Fatal("Expected no statistics before Analyze")
+	}
+
+	if err := db.Analyze("PEOPLE"); err != nil {
+		t.Fatal(err)
+	}
+
+	if db, err = LoadDatabase("analyze.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	statistics := db.AllTables()[0].Statistics
+	if statistics == nil {
+		t.Fatal("Expected statistics to be loaded")
+	}
+
+	table, _ := db.table("PEOPLE")
+	perBlock := table.recordsPerBlock(db.blockSize)
+	blocks := int64((1200 + perBlock - 1) / perBlock)
+	if statistics.RowCount != 1200 || statistics.BlockCount != blocks {
+		t.Fatalf("Expected 1200 rows in %d blocks, got %d rows in %d blocks", blocks, statistics.RowCount, statistics.BlockCount)
+	}
+
+	id, name := statistics.Columns[0], statistics.Columns[1]
+	if id.MinValue != int64(0) || id.MaxValue != int64(1199) || id.NullFraction != 0 {
+		t.Fatalf("Unexpected ID statistics %+v", id)
+	}
+
+	if id.DistinctValues < 960 || id.DistinctValues > 1440 {
+		t.Fatalf("Expected about 1200 distinct IDs, got %d", id.DistinctValues)
+	}
+
+	if name.MinValue != "ANA" || name.MaxValue != "MARIA" || name.NullFraction != 0.25 || name.DistinctValues != 3 {
+		t.Fatalf("Unexpected NAME statistics %+v", name)
+	}
+
+	if err := db.Drop("PEOPLE"); err != nil {
+		t.Fatal(err)
+	}
+
+	if set, err := db.tableSet(db.sysStatistics()); err != nil {
+		t.Fatal(err)
+	} else if len(set) != 0 {
+		t.Fatalf("Expected the statistics of dropped tables to be deleted, got %d rows", len(set))
+	}
+}
+
+func TestSelfJoin(t *testing.T) {
+	db, err := NewDatabase("aliases.db", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	columns := []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ID", nil, false, false, true, false),
+		common.NewCharTableColumn("NAME", nil, false, false, false, false, 10),
+		common.NewIntegerTableColumn("MANAGER_ID", nil, true, false, false, false),
+	}
+
+	if err := db.NewTable("EMPLOYEES", columns); err != nil {
+		t.Fatal(err)
+	}
+
+	employees := []map[string]interface{}{
+		{"ID": int64(1), "NAME": "ANA", "MANAGER_ID": nil},
+		{"ID": int64(2), "NAME": "LUIS", "MANAGER_ID": int64(1)},
+		{"ID": int64(3), "NAME": "MARIA", "MANAGER_ID": int64(2)},
+	}
+
+	for _, employee := range employees {
+		if err := db.Insert("EMPLOYEES", employee); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := tableName("EMPLOYEES AS E"); err == nil {
+		t.Fatal("Expected an alias written after the table name to be rejected")
+	}
+
+	criteria := NewComparisonExpression(Equal, NewColumnExpression("E.MANAGER_ID"), NewColumnExpression("M.ID"))
+	query := selectQuery{
+		table:   "EMPLOYEES",
+		joins:   []joinClause{{table: "EMPLOYEES", criteria: criteria}},
+		columns: []projectedColumn{{name: "E.NAME"}, {name: "M.NAME"}},
+		limit:   noLimit,
+	}
+
+	for _, option := range []SelectOption{As("E"), JoinAs(0, "M")} {
+		option(&query)
+	}
+
+	JoinAs(1, "X")(&query)
+	if query.optionErr == nil {
+		t.Fatal("Expected aliasing a missing join to fail")
+	}
+	query.optionErr = nil
+
+	result, err := db.selectSet(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	managers := map[string]string{}
+	for _, tuple := range result {
+		managers[trimName(tuple["E.NAME"].(dbChar))] = trimName(tuple["M.NAME"].(dbChar))
+	}
+
+	if len(managers) != 2 || managers["LUIS"] != "ANA" || managers["MARIA"] != "LUIS" {
+		t.Fatalf("Unexpected employee/manager pairs %v", managers)
+	}
+
+	query.alias = ""
+	query.joins[0].alias = ""
+	if _, err := db.selectSet(context.Background(), query); err == nil {
+		t.Fatal("Expected a self-join without aliases to be rejected")
+	}
+}
+
+func TestViews(t *testing.T) {
+	db, err := NewDatabase("views.db", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	columns := []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ID", nil, false, false, true, false),
+		common.NewCharTableColumn("NAME", nil, false, false, false, false, 10),
+		common.NewIntegerTableColumn("AGE", nil, false, false, false, false),
+	}
+
+	if err := db.NewTable("PEOPLE", columns); err != nil {
+		t.Fatal(err)
+	}
+
+	people := []map[string]interface{}{
+		{"ID": int64(1), "NAME": "ANA", "AGE": int64(34)},
+		{"ID": int64(2), "NAME": "LUIS", "AGE": int64(12)},
+		{"ID": int64(3), "NAME": "MARIA", "AGE": int64(51)},
+	}
+
+	for _, person := range people {
+		if err := db.Insert("PEOPLE", person); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A long condition makes the stored definition span several SYS_VIEWS rows.
+	var condition common.Expression = NewComparisonExpression(GreaterOrEqual, NewColumnExpression("AGE"), NewLiteralExpression(int64(18)))
+	condition = NewAndExpression(condition, NewComparisonExpression(NotEqual, NewColumnExpression("PEOPLE.ID"), NewLiteralExpression(int64(3))))
+	for i := 0; i < 10; i++ {
+		condition = NewAndExpression(condition, NewIsNullExpression(NewColumnExpression("PEOPLE.NAME"), true))
+	}
+
+	shout, _ := NewFunctionExpression("LOWER", NewColumnExpression("NAME"))
+	adults := selectQuery{
+		table:     "PEOPLE",
+		condition: condition,
+		columns:   []projectedColumn{{name: "PEOPLE.ID"}, {name: "LOWER_NAME", expression: shout}},
+		limit:     noLimit,
+	}
+
+	opaque := adults
+	opaque.condition = common.NewEqCommon(common.NewIdCommon("PEOPLE", "ID"), common.NewIntCommon(3))
+	if err := db.createView(context.Background(), "OPAQUE", opaque); err == nil || !strings.Contains(err.Error(), "can't be stored") {
+		t.Fatalf("Expected a view with an expression of package common to be rejected, got %v", err)
+	}
+
+	if err := db.createView(context.Background(), "ADULTS", adults); err != nil {
+		t.Fatal(err)
+	}
+
+	if db, err = LoadDatabase("views.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	views := db.AllViews()
+	if len(views) != 1 || len(views[0].ViewColumns) != 2 || views[0].ViewColumns[1].ColumnName != "ADULTS.LOWER_NAME" {
+		t.Fatalf("Unexpected views %+v", views)
+	}
+
+	criteria := NewComparisonExpression(Equal, NewColumnExpression("A.ID"), NewColumnExpression("P.ID"))
+	query := selectQuery{
+		table:     "ADULTS",
+		alias:     "A",
+		joins:     []joinClause{{table: "PEOPLE", alias: "P", criteria: criteria}},
+		condition: NewComparisonExpression(Greater, NewColumnExpression("P.AGE"), NewLiteralExpression(int64(40))),
+		columns:   []projectedColumn{{name: "A.LOWER_NAME"}},
+		limit:     noLimit,
+	}
+
+	result, err := db.selectSet(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result) != 0 {
+		t.Fatalf("Expected maria to be left out of the view, got %v", result)
+	}
+
+	query.condition = NewComparisonExpression(Greater, NewColumnExpression("P.AGE"), NewLiteralExpression(int64(30)))
+	if result, err = db.selectSet(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result) != 1 || stdType(result[0]["A.LOWER_NAME"]) != "ana" {
+		t.Fatalf("Expected only ana, got %v", result)
+	}
+
+	if err := db.Drop("PEOPLE"); err == nil {
+		t.Fatal("Expected dropping a table used by a view to fail")
+	}
+
+	if err := db.DropView("ADULTS"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.selectSet(context.Background(), query); err == nil {
+		t.Fatal("Expected selecting from a dropped view to fail")
+	}
+
+	if err := db.Drop("PEOPLE"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMaterializedViews(t *testing.T) {
+	db, err := NewDatabase("matviews.db", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	columns := []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ID", nil, false, false, true, false),
+		common.NewCharTableColumn("NAME", nil, false, false, false, false, 10),
+		common.NewIntegerTableColumn("AGE", nil, false, false, false, false),
+	}
+
+	if err := db.NewTable("PEOPLE", columns); err != nil {
+		t.Fatal(err)
+	}
+
+	insert := func(id int64, name string, age int64) {
+		if err := db.Insert("PEOPLE", map[string]interface{}{"ID": id, "NAME": name, "AGE": age}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	insert(1, "ANA", 34)
+	insert(2, "LUIS", 12)
+
+	lower, _ := NewFunctionExpression("LOWER", NewColumnExpression("P.NAME"))
+	adults := selectQuery{
+		table:     "PEOPLE",
+		alias:     "P",
+		condition: NewComparisonExpression(GreaterOrEqual, NewColumnExpression("P.AGE"), NewLiteralExpression(int64(18))),
+		columns:   []projectedColumn{{name: "P.ID"}, {name: "LOWER_NAME", expression: lower}},
+		limit:     noLimit,
+	}
+
+	if err := db.createMaterializedView(context.Background(), "ADULTS", adults); err != nil {
+		t.Fatal(err)
+	}
+
+	ages := selectQuery{table: "PEOPLE", columns: []projectedColumn{{name: "PEOPLE.AGE"}}, distinct: true, limit: noLimit}
+	if err := db.createMaterializedView(context.Background(), "AGES", ages); err != nil {
+		t.Fatal(err)
+	}
+
+	if db, err = LoadDatabase("matviews.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	insert(3, "MARIA", 51)
+	insert(4, "PEDRO", 34)
+	insert(5, "ANA", 34)
+
+	condition := NewComparisonExpression(Equal, NewColumnExpression("ID"), NewLiteralExpression(int64(1)))
+	if _, err := db.Delete("PEOPLE", condition); err != nil {
+		t.Fatal(err)
+	}
+
+	count := func(name string) int {
+		table, err := db.table(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		set, err := db.tableSet(*table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(set)
+	}
+
+	// ADULTS is maintained incrementally and keeps one of the two rows of ANA.
+	if n := count("ADULTS"); n != 3 {
+		t.Fatalf("Expected 3 adults, got %d", n)
+	}
+
+	if n := count("AGES"); n != 2 {
+		t.Fatalf("Expected AGES to keep 2 rows until refreshed, got %d", n)
+	}
+
+	if err := db.RefreshMaterializedView("AGES"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := count("AGES"); n != 3 {
+		t.Fatalf("Expected 3 distinct ages after refreshing, got %d", n)
+	}
+
+	if err := db.Insert("AGES", map[string]interface{}{}); err == nil {
+		t.Fatal("Expected inserting into a materialized view to fail")
+	}
+
+	if _, err := db.Delete("AGES", nil); err == nil {
+		t.Fatal("Expected deleting from a materialized view to fail")
+	}
+
+	if err := db.Truncate("AGES"); err == nil {
+		t.Fatal("Expected truncating a materialized view to fail")
+	}
+
+	if err := db.Drop("PEOPLE"); err == nil {
+		t.Fatal("Expected dropping a table used by a materialized view to fail")
+	}
+
+	if err := db.DropView("ADULTS"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := db.dbMaterializedViews["ADULTS"]; ok {
+		t.Fatal("Expected ADULTS to be dropped")
+	}
+}
+
+func TestInsertSelect(t *testing.T) {
+	db, err := NewDatabase("insertselect.db", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.NewTable("PEOPLE", []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ID", nil, false, false, true, false),
+		common.NewCharTableColumn("NAME", nil, false, false, false, false, 10),
+		common.NewIntegerTableColumn("AGE", nil, false, false, false, false),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.NewTable("ARCHIVE", []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ID", nil, false, false, true, false),
+		common.NewCharTableColumn("NAME", nil, false, false, false, false, 10),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, name := range []string{"ANA", "LUIS", "MARIA"} {
+		values := map[string]interface{}{"ID": int64(i + 1), "NAME": name, "AGE": int64(12 + 20*i)}
+		if err := db.Insert("PEOPLE", values); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	insertSelect := func(name string, columns []string, query selectQuery) error {
+		return db.atomically(func() error {
+			return db.insertSelect(context.Background(), name, columns, query)
+		})
+	}
+
+	count := func(name string) int {
+		table, err := db.table(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		set, err := db.tableSet(*table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(set)
+	}
+
+	adults := selectQuery{
+		table:     "PEOPLE",
+		condition: NewComparisonExpression(GreaterOrEqual, NewColumnExpression("AGE"), NewLiteralExpression(int64(18))),
+		columns:   []projectedColumn{{name: "PEOPLE.ID"}, {name: "PEOPLE.NAME"}},
+		limit:     noLimit,
+	}
+
+	if err := insertSelect("ARCHIVE", nil, adults); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := count("ARCHIVE"); n != 2 {
+		t.Fatalf("Expected 2 archived rows, got %d", n)
+	}
+
+	// The columns are mapped by position, so NAME would receive an INTEGER.
+	if err := insertSelect("ARCHIVE", []string{"NAME", "ID"}, adults); err == nil {
+		t.Fatal("Expected inserting an INTEGER into a CHAR column to fail")
+	}
+
+	if n := count("ARCHIVE"); n != 2 {
+		t.Fatalf("Expected the failed insert to be rolled back, got %d rows", n)
+	}
+
+	id := NewArithmeticExpression(Addition, NewColumnExpression("ID"), NewLiteralExpression(int64(10)))
+	copies := selectQuery{
+		table:   "PEOPLE",
+		columns: []projectedColumn{{name: "NEW_ID", expression: id}, {name: "PEOPLE.NAME"}, {name: "PEOPLE.AGE"}},
+		limit:   noLimit,
+	}
+
+	if err := insertSelect("PEOPLE", []string{"ID", "NAME", "AGE"}, copies); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := count("PEOPLE"); n != 6 {
+		t.Fatalf("Expected the rows of PEOPLE to be copied once, got %d rows", n)
+	}
+
+	if err := db.AddUniqueConstraint("ARCHIVE", "ARCHIVE_NAME", "NAME"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every name of PEOPLE appears twice, so the statement violates ARCHIVE_NAME as a whole.
+	renumbered := selectQuery{
+		table:   "PEOPLE",
+		columns: []projectedColumn{{name: "NEW_ID", expression: NewArithmeticExpression(Addition, NewColumnExpression("ID"), NewLiteralExpression(int64(100)))}, {name: "PEOPLE.NAME"}},
+		limit:   noLimit,
+	}
+
+	if err := insertSelect("ARCHIVE", nil, renumbered); err == nil || !strings.Contains(err.Error(), "ARCHIVE_NAME") {
+		t.Fatalf("Expected a violation of ARCHIVE_NAME, got %v", err)
+	}
+
+	if n := count("ARCHIVE"); n != 2 {
+		t.Fatalf("Expected the failed insert to be rolled back, got %d rows", n)
+	}
+}
+
+func TestDeleteReturning(t *testing.T) {
+	db, err := NewDatabase("returning.db", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.NewTable("PEOPLE", []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ID", nil, false, false, true, false),
+		common.NewCharTableColumn("NAME", nil, false, false, false, false, 10),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, name := range []string{"ANA", "LUIS", "MARIA"} {
+		if err := db.Insert("PEOPLE", map[string]interface{}{"ID": int64(i + 1), "NAME": name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	missing := NewComparisonExpression(Equal, NewColumnExpression("ID"), NewLiteralExpression(int64(9)))
+	result, err := db.Delete("PEOPLE", missing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.RowsAffected != 0 || len(result.Rows) != 0 {
+		t.Fatalf("Expected no rows to be deleted, got %d", result.RowsAffected)
+	}
+
+	luis := NewComparisonExpression(Equal, NewColumnExpression("NAME"), NewLiteralExpression("LUIS"))
+	result, err = db.Delete("PEOPLE", luis, Returning())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.RowsAffected != 1 || len(result.Rows) != 1 {
+		t.Fatalf("Expected 1 deleted row, got %d", result.RowsAffected)
+	}
+
+	if row := result.Rows[0]; row.Before["ID"] != int64(2) || row.Before["NAME"] != "LUIS" || row.After != nil {
+		t.Fatalf("Unexpected deleted row %v", row)
+	}
+
+	// The free slots of the block hold stale tuples with ID 0, which the condition must not see.
+	quotient := NewArithmeticExpression(Division, NewLiteralExpression(int64(100)), NewColumnExpression("ID"))
+	if result, err = db.Delete("PEOPLE", NewComparisonExpression(Greater, quotient, NewLiteralExpression(int64(1000)))); err != nil {
+		t.Fatal(err)
+	} else if result.RowsAffected != 0 {
+		t.Fatalf("Expected no rows to be deleted, got %d", result.RowsAffected)
+	}
+
+	ana := NewComparisonExpression(Equal, NewColumnExpression("ID"), NewLiteralExpression(int64(1)))
+	table, _ := db.table("PEOPLE")
+	result = newModifyResult([]ModifyOption{Returning()})
+	if err := db.atomically(func() error {
+		return db.update(context.Background(), *table, setValues{condition: ana, values: map[string]interface{}{"NAME": "ANNA"}}, result)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if result.RowsAffected != 1 || len(result.Rows) != 1 {
+		t.Fatalf("Expected 1 updated row, got %d", result.RowsAffected)
+	}
+
+	if row := result.Rows[0]; row.Before["NAME"] != "ANA" || row.After["NAME"] != "ANNA" || row.After["ID"] != int64(1) {
+		t.Fatalf("Unexpected updated row %v", row)
+	}
+
+	// Without Returning only the rows are counted.
+	result, err = db.Delete("PEOPLE", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.RowsAffected != 2 || len(result.Rows) != 0 {
+		t.Fatalf("Expected 2 deleted rows and no images, got %d and %d", result.RowsAffected, len(result.Rows))
+	}
+}
+
+func TestUniqueConstraints(t *testing.T) {
+	db, err := NewDatabase("unique.db", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.NewTable("PEOPLE", []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ID", nil, false, false, true, false),
+		common.NewCharTableColumn("EMAIL", nil, true, false, false, false, 20),
+		common.NewCharTableColumn("FIRST", nil, false, false, false, false, 10),
+		common.NewCharTableColumn("LAST", nil, false, false, false, false, 10),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	insert := func(id int64, email interface{}, first string, last string) error {
+		return db.Insert("PEOPLE", map[string]interface{}{"ID": id, "EMAIL": email, "FIRST": first, "LAST": last})
+	}
+
+	if err := insert(1, "ana@mail", "ANA", "DIAZ"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := insert(2, "ana@mail", "ANA", "DIAZ"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.AddUniqueConstraint("PEOPLE", "PEOPLE_EMAIL", "EMAIL"); err == nil {
+		t.Fatal("Expected adding a UNIQUE constraint over duplicates to fail")
+	}
+
+	if _, err := db.Delete("PEOPLE", NewComparisonExpression(Equal, NewColumnExpression("ID"), NewLiteralExpression(int64(2)))); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.AddUniqueConstraint("PEOPLE", "PEOPLE_EMAIL", "EMAIL"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.AddUniqueConstraint("PEOPLE", "PEOPLE_NAME", "FIRST", "LAST"); err != nil {
+		t.Fatal(err)
+	}
+
+	if db, err = LoadDatabase("unique.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := insert(2, "ana@mail", "LUIS", "DIAZ"); err == nil || !strings.Contains(err.Error(), "PEOPLE_EMAIL") {
+		t.Fatalf("Expected a violation of PEOPLE_EMAIL, got %v", err)
+	}
+
+	if err := insert(2, "luis@mail", "ANA", "DIAZ"); err == nil || !strings.Contains(err.Error(), "PEOPLE_NAME") {
+		t.Fatalf("Expected a violation of PEOPLE_NAME, got %v", err)
+	}
+
+	// NULLs never conflict, and neither do keys sharing only some of their columns.
+	if err := insert(2, nil, "ANA", "SOTO"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := insert(3, nil, "LUIS", "DIAZ"); err != nil {
+		t.Fatal(err)
+	}
+
+	table := db.AllTables()[0]
+	if len(table.UniqueConstraints) != 2 || table.UniqueConstraints[1].ConstraintName != "PEOPLE_NAME" {
+		t.Fatalf("Unexpected unique constraints %v", table.UniqueConstraints)
+	}
+
+	for _, column := range table.TableColumns {
+		if column.Unique != (column.ColumnName == "PEOPLE.EMAIL") {
+			t.Fatalf("Unexpected Unique flag on column `%s'", column.ColumnName)
+		}
+	}
+}
+
+func TestCheckConstraints(t *testing.T) {
+	db, err := NewDatabase("checks.db", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.NewTable("ACCOUNTS", []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ID", nil, false, false, true, false),
+		common.NewIntegerTableColumn("BALANCE", nil, true, false, false, false),
+		common.NewIntegerTableColumn("CREDIT", nil, false, false, false, false),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	insert := func(id int64, balance interface{}, credit int64) error {
+		return db.Insert("ACCOUNTS", map[string]interface{}{"ID": id, "BALANCE": balance, "CREDIT": credit})
+	}
+
+	if err := insert(1, int64(-50), 10); err != nil {
+		t.Fatal(err)
+	}
+
+	covered := NewComparisonExpression(GreaterOrEqual,
+		NewArithmeticExpression(Addition, NewColumnExpression("BALANCE"), NewColumnExpression("CREDIT")),
+		NewLiteralExpression(int64(0)))
+
+	if err := db.AddCheckConstraint("ACCOUNTS", "BALANCE_COVERED", covered); err == nil {
+		t.Fatal("Expected adding a CHECK constraint violated by a stored row to fail")
+	}
+
+	if _, err := db.Delete("ACCOUNTS", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.AddCheckConstraint("ACCOUNTS", "BALANCE_COVERED", covered); err != nil {
+		t.Fatal(err)
+	}
+
+	positive := NewComparisonExpression(GreaterOrEqual, NewColumnExpression("CREDIT"), NewLiteralExpression(int64(0)))
+	if err := db.AddColumnCheckConstraint("ACCOUNTS", "BALANCE", "POSITIVE_CREDIT", positive); err == nil {
+		t.Fatal("Expected a column check referencing another column to fail")
+	}
+
+	if err := db.AddColumnCheckConstraint("ACCOUNTS", "CREDIT", "POSITIVE_CREDIT", positive); err != nil {
+		t.Fatal(err)
+	}
+
+	opaque := NewNotExpression(common.NewEqCommon(common.NewIdCommon("ACCOUNTS", "ID"), common.NewIntCommon(99)))
+	if err := db.AddCheckConstraint("ACCOUNTS", "RESERVED_ID", opaque); err == nil {
+		t.Fatal("Expected a CHECK constraint with an expression of package common to be rejected")
+	}
+
+	reserved := NewComparisonExpression(NotEqual, NewColumnExpression("ACCOUNTS.ID"), NewLiteralExpression(int64(99)))
+	if err := db.AddCheckConstraint("ACCOUNTS", "RESERVED_ID", reserved); err != nil {
+		t.Fatal(err)
+	}
+
+	if db, err = LoadDatabase("checks.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := insert(1, int64(-50), 10); err == nil || !strings.Contains(err.Error(), "BALANCE_COVERED") {
+		t.Fatalf("Expected a violation of BALANCE_COVERED, got %v", err)
+	}
+
+	if err := insert(1, int64(50), -10); err == nil || !strings.Contains(err.Error(), "POSITIVE_CREDIT") {
+		t.Fatalf("Expected a violation of POSITIVE_CREDIT, got %v", err)
+	}
+
+	if err := insert(99, int64(50), 10); err == nil || !strings.Contains(err.Error(), "RESERVED_ID") {
+		t.Fatalf("Expected a violation of RESERVED_ID, got %v", err)
+	}
+
+	// A NULL balance makes BALANCE_COVERED unknown, which doesn't violate it.
+	if err := insert(1, nil, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	checks := db.AllTables()[0].CheckConstraints
+	if len(checks) != 3 || checks[1].ConstraintName != "POSITIVE_CREDIT" || checks[1].ColumnName != "ACCOUNTS.CREDIT" {
+		t.Fatalf("Unexpected check constraints %v", checks)
+	}
+}
+
+// setValues is an update command setting values on the rows satisfying condition.
+type setValues struct {
+	condition common.Expression
+	values    map[string]interface{}
+}
+
+func (u setValues) Condition() common.Expression {
+	return u.condition
+}
+
+func (u setValues) Values(symbols map[string]interface{}) map[string]interface{} {
+	return u.values
+}
+
+func TestTriggers(t *testing.T) {
+	db, err := NewDatabase("triggers.db", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.NewTable("ITEMS", []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ID", nil, false, false, true, false),
+		common.NewIntegerTableColumn("QTY", nil, true, false, false, false),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.NewTable("AUDIT", []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ITEM_ID", nil, false, false, false, false),
+		common.NewCharTableColumn("ACTION", nil, false, false, false, false, 10),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	audit := func(action string) TriggerFunc {
+		return func(old map[string]interface{}, new map[string]interface{}) error {
+			row := new
+			if row == nil {
+				row = old
+			}
+			return db.Insert("AUDIT", map[string]interface{}{"ITEM_ID": row["ID"], "ACTION": action})
+		}
+	}
+
+	triggers := []struct {
+		event TriggerEvent
+		fn    TriggerFunc
+	}{
+		{BeforeInsert, func(old map[string]interface{}, new map[string]interface{}) error {
+			if new["QTY"] == nil {
+				new["QTY"] = int64(1)
+			}
+			return nil
+		}},
+		{AfterInsert, audit("INSERT")},
+		{BeforeDelete, func(old map[string]interface{}, new map[string]interface{}) error {
+			if old["QTY"].(int64) > 5 {
+				return fmt.Errorf("item %d is in stock", old["ID"])
+			}
+			return nil
+		}},
+		{AfterDelete, audit("DELETE")},
+	}
+
+	for _, trigger := range triggers {
+		if err := db.RegisterTrigger("ITEMS", trigger.event, trigger.fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.Insert("ITEMS", map[string]interface{}{"ID": int64(1)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Insert("ITEMS", map[string]interface{}{"ID": int64(2), "QTY": int64(10)}); err != nil {
+		t.Fatal(err)
+	}
+
+	count := func(name string) int {
+		table, err := db.table(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		set, err := db.tableSet(*table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(set)
+	}
+
+	items, _ := db.table("ITEMS")
+	set, err := db.tableSet(*items)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, row := range set {
+		if row["ITEMS.ID"] == dbInteger(1) && row["ITEMS.QTY"] != dbInteger(1) {
+			t.Fatalf("Expected the BEFORE INSERT trigger to set QTY, got %v", row["ITEMS.QTY"])
+		}
+	}
+
+	// Item 2 vetoes the delete, so item 1 and its audit row survive too.
+	if _, err := db.Delete("ITEMS", nil); err == nil {
+		t.Fatal("Expected the BEFORE DELETE trigger to veto the delete")
+	}
+
+	if n := count("ITEMS"); n != 2 {
+		t.Fatalf("Expected 2 items after the vetoed delete, got %d", n)
+	}
+
+	if n := count("AUDIT"); n != 2 {
+		t.Fatalf("Expected 2 audit rows after the vetoed delete, got %d", n)
+	}
+
+	small := NewComparisonExpression(Less, NewColumnExpression("QTY"), NewLiteralExpression(int64(5)))
+	if _, err := db.Delete("ITEMS", small); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := count("AUDIT"); n != 3 {
+		t.Fatalf("Expected 3 audit rows, got %d", n)
+	}
+
+	update := func(cmd setValues) error {
+		return db.atomically(func() error {
+			return db.update(context.Background(), *items, cmd, nil)
+		})
+	}
+
+	err = db.RegisterTrigger("ITEMS", BeforeUpdate, func(old map[string]interface{}, new map[string]interface{}) error {
+		new["QTY"] = new["QTY"].(int64) * 2
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := update(setValues{values: map[string]interface{}{"QTY": int64(4)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if set, err = db.tableSet(*items); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(set) != 1 || set[0]["ITEMS.QTY"] != dbInteger(8) {
+		t.Fatalf("Expected the BEFORE UPDATE trigger to double QTY, got %v", set)
+	}
+
+	// The block holding the row is written back after the trigger, which would undo the insert.
+	err = db.RegisterTrigger("ITEMS", BeforeUpdate, func(old map[string]interface{}, new map[string]interface{}) error {
+		return db.Insert("ITEMS", map[string]interface{}{"ID": int64(3), "QTY": int64(1)})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := update(setValues{values: map[string]interface{}{"QTY": int64(5)}}); err == nil {
+		t.Fatal("Expected a BEFORE UPDATE trigger writing its own table to fail")
+	}
+
+	if n := count("ITEMS"); n != 1 {
+		t.Fatalf("Expected the failed update to be rolled back, got %d items", n)
+	}
+
+	// AFTER triggers run once the scan is over, so the rows they add to their own table
+	// aren't updated or deleted by the statement that fired them.
+	if err := db.NewTable("TICKETS", []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ID", nil, false, false, false, false),
+		common.NewIntegerTableColumn("STATE", nil, false, false, false, false),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := int64(1); i <= 50; i++ {
+		if err := db.Insert("TICKETS", map[string]interface{}{"ID": i, "STATE": int64(0)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reopen := func(old map[string]interface{}, new map[string]interface{}) error {
+		return db.Insert("TICKETS", map[string]interface{}{"ID": old["ID"].(int64) + 100, "STATE": int64(0)})
+	}
+
+	for _, event := range []TriggerEvent{AfterUpdate, AfterDelete} {
+		if err := db.RegisterTrigger("TICKETS", event, reopen); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tickets, _ := db.table("TICKETS")
+	isClosed := NewComparisonExpression(Equal, NewColumnExpression("STATE"), NewLiteralExpression(int64(1)))
+	states := func() (open int, closed int) {
+		set, err := db.tableSet(*tickets)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, row := range set {
+			if row["TICKETS.STATE"] == dbInteger(0) {
+				open++
+			} else {
+				closed++
+			}
+		}
+		return open, closed
+	}
+
+	if err := db.atomically(func() error {
+		return db.update(context.Background(), *tickets, setValues{values: map[string]interface{}{"STATE": int64(1)}}, nil)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if open, closed := states(); open != 50 || closed != 50 {
+		t.Fatalf("Expected 50 closed tickets and 50 reopened ones, got %d and %d", closed, open)
+	}
+
+	if _, err := db.Delete("TICKETS", isClosed); err != nil {
+		t.Fatal(err)
+	}
+
+	if open, closed := states(); open != 100 || closed != 0 {
+		t.Fatalf("Expected the 50 closed tickets to be replaced by open ones, got %d open and %d closed", open, closed)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	db, err := NewDatabase("subscribe.db", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all := db.Subscribe()
+	items := db.Subscribe("ITEMS")
+
+	columns := []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ID", nil, false, false, true, false),
+		common.NewIntegerTableColumn("QTY", nil, false, false, false, false),
+	}
+
+	if err := db.NewTable("ITEMS", columns); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.NewTable("OTHER", columns); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := int64(1); i <= 2; i++ {
+		if err := db.Insert("ITEMS", map[string]interface{}{"ID": i, "QTY": 10 * i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Failed operations publish nothing and don't use sequence numbers.
+	if err := db.Insert("ITEMS", map[string]interface{}{"ID": int64(3), "QTY": "many"}); err == nil {
+		t.Fatal("Expected inserting a CHAR into an INTEGER column to fail")
+	}
+
+	condition := NewComparisonExpression(Equal, NewColumnExpression("ID"), NewLiteralExpression(int64(1)))
+	if _, err := db.Delete("ITEMS", condition); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Drop("ITEMS"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ChangeOperation{ChangeCreateTable, ChangeInsert, ChangeInsert, ChangeDelete, ChangeDropTable}
+	events := []ChangeEvent{}
+	for range expected {
+		events = append(events, <-items.Events())
+	}
+
+	for i, event := range events {
+		if event.Operation != expected[i] || event.Table != "ITEMS" {
+			t.Fatalf("Expected event %d to be %s of ITEMS, got %s of %s", i, expected[i], event.Operation, event.Table)
+		}
+
+		if i > 0 && event.Sequence <= events[i-1].Sequence {
+			t.Fatalf("Expected increasing sequence numbers, got %d after %d", event.Sequence, events[i-1].Sequence)
+		}
+	}
+
+	if deleted := events[3]; deleted.Key["ID"] != int64(1) || deleted.Old["QTY"] != int64(10) || deleted.New != nil {
+		t.Fatalf("Unexpected delete event %v", deleted)
+	}
+
+	if n := len(all.Events()); n != len(expected)+1 {
+		t.Fatalf("Expected %d events for all tables, got %d", len(expected)+1, n)
+	}
+
+	// A subscriber resuming after the second insert gets the delete and the drop.
+	resumed, err := db.SubscribeFrom(events[2].Sequence, "ITEMS")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if event := <-resumed.Events(); event.Sequence != events[3].Sequence {
+		t.Fatalf("Expected to resume with event %d, got %d", events[3].Sequence, event.Sequence)
+	}
+
+	// all isn't read, so it overflows.
+	for i := int64(1); i <= changeBufferSize; i++ {
+		if err := db.Insert("OTHER", map[string]interface{}{"ID": i, "QTY": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if all.Err() != ErrSubscriptionOverflow {
+		t.Fatalf("Expected %v, got %v", ErrSubscriptionOverflow, all.Err())
+	}
+
+	last := db.changeSequence
+	if db, err = LoadDatabase("subscribe.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.SubscribeFrom(last); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.SubscribeFrom(events[2].Sequence); err != ErrChangesUnavailable {
+		t.Fatalf("Expected resuming from changes lost by reloading to ask for a resync, got %v", err)
+	}
+}
+
+func TestSequences(t *testing.T) {
+	db, err := NewDatabase("sequences.db", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateSequence("IDS", StartWith(10), IncrementBy(5)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateSequence("IDS"); err == nil {
+		t.Fatal("Expected creating a duplicate sequence to fail")
+	}
+
+	if _, err := db.CurrentValue("IDS"); err == nil {
+		t.Fatal("Expected CURRVAL before NEXTVAL to fail")
+	}
+
+	for _, expected := range []int64{10, 15} {
+		if value, err := db.NextValue("IDS"); err != nil || value != expected {
+			t.Fatalf("Expected NEXTVAL %d, got %d (%v)", expected, value, err)
+		}
+	}
+
+	if value, err := db.CurrentValue("IDS"); err != nil || value != 15 {
+		t.Fatalf("Expected CURRVAL 15, got %d (%v)", value, err)
+	}
+
+	if err := db.CreateSequence("DICE", MinValue(1), MaxValue(3), Cycle()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateSequence("ONCE", MaxValue(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []int64{1, 2, 3, 1} {
+		if value, err := db.NextValue("DICE"); err != nil || value != expected {
+			t.Fatalf("Expected DICE %d, got %d (%v)", expected, value, err)
+		}
+	}
+
+	if _, err := db.NextValue("ONCE"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.NextValue("ONCE"); err == nil {
+		t.Fatal("Expected an exhausted sequence to fail")
+	}
+
+	if err := db.NewTable("ITEMS", []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ID", NextVal("IDS"), false, false, true, false),
+		common.NewIntegerTableColumn("COPY", nil, true, false, false, false),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Insert("ITEMS", map[string]interface{}{"COPY": CurrVal("IDS")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Insert("ITEMS", map[string]interface{}{"ID": NextVal("IDS"), "COPY": CurrVal("IDS")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed insert still uses its value.
+	if err := db.Insert("ITEMS", map[string]interface{}{"COPY": "many"}); err == nil {
+		t.Fatal("Expected inserting a CHAR into an INTEGER column to fail")
+	}
+
+	if err := db.DropSequence("IDS"); err == nil {
+		t.Fatal("Expected dropping a sequence used by a column default to fail")
+	}
+
+	if db, err = LoadDatabase("sequences.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Values reserved before reloading are skipped rather than handed out again.
+	value, err := db.NextValue("IDS")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value <= 30 || (value-10)%5 != 0 {
+		t.Fatalf("Expected a value of IDS past 30 after reloading, got %d", value)
+	}
+
+	if err := db.Insert("ITEMS", map[string]interface{}{"COPY": nil}); err != nil {
+		t.Fatal(err)
+	}
+
+	table, _ := db.table("ITEMS")
+	set, err := db.tableSet(*table)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[dbType]bool{}
+	for _, row := range set {
+		id := row["ITEMS.ID"]
+		if seen[id] {
+			t.Fatalf("Value %v of IDS was used twice", id)
+		}
+		seen[id] = true
+
+		if copied := row["ITEMS.COPY"]; copied != nil && copied != id {
+			t.Fatalf("Expected CURRVAL %v to equal NEXTVAL %v", copied, id)
+		}
+	}
+
+	if len(seen) != 3 || !seen[dbInteger(20)] || !seen[dbInteger(25)] {
+		t.Fatalf("Unexpected rows %v", set)
+	}
+
+	if err := db.DropSequence("DICE"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.NextValue("DICE"); err == nil {
+		t.Fatal("Expected NEXTVAL of a dropped sequence to fail")
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	db, err := NewDatabase("truncate.db", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.NewTable("ITEMS", []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ID", nil, false, true, true, false),
+		common.NewCharTableColumn("NAME", nil, true, false, false, false, 40),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := int64(1); i <= 50; i++ {
+		if err := db.Insert("ITEMS", map[string]interface{}{"ID": i, "NAME": "item"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	table, _ := db.table("ITEMS")
+	blocks := int64(0)
+	for addr := int64(table.firstRecordBlockAddr); addr != nullBlockAddr; blocks++ {
+		block, err := db.readAt(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr = block.nextBlock()
+	}
+
+	if blocks < 2 {
+		t.Fatalf("Expected the rows to take several blocks, got %d", blocks)
+	}
+
+	table.dbColumns[0].dbAutoincrementCounter = 50
+	if err := db.writeColumnValue(context.Background(), table.dbColumns[0], "COLUMN_COUNTER", dbInteger(50)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Analyze("ITEMS"); err != nil {
+		t.Fatal(err)
+	}
+
+	subscription := db.Subscribe("ITEMS")
+	available := db.availableBlocks
+	if err := db.Truncate("ITEMS", RestartIdentity()); err != nil {
+		t.Fatal(err)
+	}
+
+	if table, _ = db.table("ITEMS"); table.statistics != nil {
+		t.Fatalf("Expected truncating to drop the statistics, got %+v", table.statistics)
+	}
+
+	// All blocks but the one reused as the new first block are free.
+	if db.availableBlocks != available+blocks-1 {
+		t.Fatalf("Expected %d available blocks, got %d", available+blocks-1, db.availableBlocks)
+	}
+
+	if event := <-subscription.Events(); event.Operation != ChangeTruncateTable {
+		t.Fatalf("Expected a %s event, got %s", ChangeTruncateTable, event.Operation)
+	}
+
+	if err := db.Insert("ITEMS", map[string]interface{}{"ID": int64(1), "NAME": "again"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Truncate("MISSING"); err == nil {
+		t.Fatal("Expected truncating a missing table to fail")
+	}
+
+	if db, err = LoadDatabase("truncate.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	table, _ = db.table("ITEMS")
+	set, err := db.tableSet(*table)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(set) != 1 || set[0]["ITEMS.NAME"] == nil || trimName(set[0]["ITEMS.NAME"].(dbChar)) != "again" {
+		t.Fatalf("Expected only the row inserted after truncating, got %v", set)
+	}
+
+	if counter := table.dbColumns[0].dbAutoincrementCounter; counter != 0 {
+		t.Fatalf("Expected the autoincrement counter to restart, got %d", counter)
+	}
+
+	if table.statistics != nil {
+		t.Fatalf("Expected no statistics to be loaded after truncating, got %+v", table.statistics)
+	}
+}
+
+func TestRenameTable(t *testing.T) {
+	db, err := NewDatabase("rename.db", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	columns := []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ID", nil, false, false, true, false),
+		common.NewIntegerTableColumn("QTY", nil, true, false, false, false),
+	}
+
+	if err := db.NewTable("ITEMS", columns); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.NewTable("OTHER", columns); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.AddUniqueConstraint("ITEMS", "ITEMS_ID", "ID"); err != nil {
+		t.Fatal(err)
+	}
+
+	positive := NewComparisonExpression(Greater, NewColumnExpression("QTY"), NewLiteralExpression(int64(0)))
+	if err := db.AddColumnCheckConstraint("ITEMS", "QTY", "ITEMS_QTY", positive); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Insert("ITEMS", map[string]interface{}{"ID": int64(1), "QTY": int64(5)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.RenameTable("ITEMS", "OTHER"); err == nil {
+		t.Fatal("Expected renaming to the name of another table to fail")
+	}
+
+	view := selectQuery{table: "OTHER", columns: []projectedColumn{{name: "OTHER.ID"}}, limit: noLimit}
+	if err := db.createView(context.Background(), "OTHER_IDS", view); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.RenameTable("OTHER", "ANOTHER"); err == nil {
+		t.Fatal("Expected renaming a table read by a view to fail")
+	}
+
+	if err := db.RenameTable("ITEMS", "OTHER_IDS"); err == nil {
+		t.Fatal("Expected renaming to the name of a view to fail")
+	}
+
+	// A check naming its table must fail the rename as soon as it is created, not only once reloaded.
+	if err := db.NewTable("ACCOUNTS", columns); err != nil {
+		t.Fatal(err)
+	}
+
+	qualified := NewComparisonExpression(Greater, NewColumnExpression("ACCOUNTS.ID"), NewLiteralExpression(int64(0)))
+	if err := db.AddCheckConstraint("ACCOUNTS", "ACCOUNTS_ID", qualified); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.RenameTable("ACCOUNTS", "LEDGER"); err == nil || !strings.Contains(err.Error(), "ACCOUNTS_ID") {
+		t.Fatalf("Expected a CHECK constraint naming ACCOUNTS to fail the rename, got %v", err)
+	}
+
+	if err := db.Insert("ACCOUNTS", map[string]interface{}{"ID": int64(1), "QTY": int64(5)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.RenameTable("ITEMS", "GOODS"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Insert("ITEMS", map[string]interface{}{"ID": int64(2), "QTY": int64(5)}); err == nil {
+		t.Fatal("Expected inserting into the old name to fail")
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := db.Insert("GOODS", map[string]interface{}{"ID": int64(1), "QTY": int64(5)}); err == nil || !strings.Contains(err.Error(), "ITEMS_ID") {
+			t.Fatalf("Expected a duplicate ID to violate ITEMS_ID, got %v", err)
+		}
+
+		if err := db.Insert("GOODS", map[string]interface{}{"ID": int64(3), "QTY": int64(-1)}); err == nil || !strings.Contains(err.Error(), "ITEMS_QTY") {
+			t.Fatalf("Expected a negative QTY to violate ITEMS_QTY, got %v", err)
+		}
+
+		table, err := db.table("GOODS")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		set, err := db.tableSet(*table)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(set) != 1 || set[0]["GOODS.ID"] != dbInteger(1) {
+			t.Fatalf("Expected the row of ITEMS in GOODS, got %v", set)
+		}
+
+		if db, err = LoadDatabase("rename.db"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := db.table("ITEMS"); err == nil {
+		t.Fatal("Expected ITEMS to be gone after reloading")
+	}
+}
+
+func TestFloatStorage(t *testing.T) {
+	db, err := NewDatabase("floats.db", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.NewTable("MEASURES", []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ID", nil, false, false, true, false),
+		common.NewFloatTableColumn("VALUE", nil, true, false, false, false),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	values := []float64{3.75, -2.5, math.Inf(1), math.Inf(-1), math.NaN(), math.SmallestNonzeroFloat64}
+	for i, value := range values {
+		if err := db.Insert("MEASURES", map[string]interface{}{"ID": int64(i), "VALUE": value}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if db, err = LoadDatabase("floats.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	table, _ := db.table("MEASURES")
+	set, err := db.tableSet(*table)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, row := range set {
+		expected := values[row["MEASURES.ID"].(dbInteger)]
+		if value := float64(row["MEASURES.VALUE"].(dbFloat)); math.Float64bits(value) != math.Float64bits(expected) {
+			t.Fatalf("Expected %v, got %v", expected, value)
+		}
+	}
+
+	for _, c := range []struct {
+		a, b     float64
+		expected int
+	}{
+		{math.NaN(), math.NaN(), 0},
+		{math.NaN(), math.Inf(1), 1},
+		{math.Inf(-1), math.NaN(), -1},
+		{math.Copysign(0, -1), 0, 0},
+	} {
+		if n, err := compareValues(c.a, c.b); err != nil || n != c.expected {
+			t.Fatalf("Expected comparing %v with %v to give %d, got %d (%v)", c.a, c.b, c.expected, n, err)
+		}
+	}
+
+	if tupleKey(dbTuple{"V": dbFloat(math.NaN())}) != tupleKey(dbTuple{"V": dbFloat(-math.NaN())}) {
+		t.Fatal("Expected every NaN to have the same key")
+	}
+
+}
+
+func TestFloatMigration(t *testing.T) {
+	db, err := NewDatabase("oldfloats.db", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Files from before the format version stored the truncated value's integer bits.
+	if err := db.NewTable("OLD", []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ID", nil, false, false, true, false),
+		common.NewFloatTableColumn("VALUE", 2.5, true, false, false, false),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, bits := range []uint64{3, 0, 42} {
+		if err := db.Insert("OLD", map[string]interface{}{"ID": int64(i), "VALUE": math.Float64frombits(bits)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db.formatVersion = 0
+	if err := db.writeDbInfo(); err != nil {
+		t.Fatal(err)
+	}
+
+	if db, err = LoadDatabase("oldfloats.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	if db.formatVersion != currentFormatVersion {
+		t.Fatalf("Expected format version %d after migrating, got %d", currentFormatVersion, db.formatVersion)
+	}
+
+	table, _ := db.table("OLD")
+	set, err := db.tableSet(*table)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, row := range set {
+		expected := []dbFloat{3, 0, 42}[row["OLD.ID"].(dbInteger)]
+		if value := row["OLD.VALUE"]; value != expected {
+			t.Fatalf("Expected %v after migrating, got %v", expected, value)
+		}
+	}
+
+	// Migrated files are left alone on the next load.
+	if db, err = LoadDatabase("oldfloats.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	table, _ = db.table("OLD")
+	if set, err = db.tableSet(*table); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(set) != 3 || set[0]["OLD.VALUE"] != dbFloat(3) {
+		t.Fatalf("Expected the migrated values to be kept, got %v", set)
+	}
+}
+
+func TestVarchar(t *testing.T) {
+	db, err := NewDatabase("varchar.db", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.NewTable("DOCS", []common.TableColumnDefiner{
+		common.NewIntegerTableColumn("ID", nil, false, false, true, false),
+		NewVarcharTableColumn("TITLE", nil, true, false, false, false, 1000),
+		common.NewCharTableColumn("TAG", nil, true, false, false, false, 8),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.NewTable("BAD", []common.TableColumnDefiner{
+		NewVarcharTableColumn("TEXT", nil, true, false, false, false, maxVarcharLength+1),
+	}); err == nil {
+		t.Fatal("Expected a VARCHAR longer than the maximum to fail")
+	}
+
+	if err := db.NewTable("BAD", []common.TableColumnDefiner{
+		NewVarcharTableColumn("TEXT", strings.Repeat("x", maxCharLength+1), true, false, false, false, 1000),
+	}); err == nil {
+		t.Fatal("Expected a default longer than a stored default to fail")
+	}
+
+	titles := map[int64]interface{}{}
+	for i := int64(1); i <= 60; i++ {
+		titles[i] = fmt.Sprintf("doc %d", i)
+		if i%10 == 0 {
+			titles[i] = nil
+		}
+
+		if err := db.Insert("DOCS", map[string]interface{}{"ID": i, "TITLE": titles[i], "TAG": "t"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.Insert("DOCS", map[string]interface{}{"ID": int64(61), "TITLE": strings.Repeat("x", 1001)}); err == nil {
+		t.Fatal("Expected a value longer than the VARCHAR to fail")
+	}
+
+	if err := db.Insert("DOCS", map[string]interface{}{"ID": int64(61), "TITLE": strings.Repeat("x", 600)}); err == nil || !strings.Contains(err.Error(), "doesn't fit") {
+		t.Fatalf("Expected a row larger than a block to fail, got %v", err)
+	}
+
+	table, _ := db.table("DOCS")
+	blocks := func() (n int) {
+		for addr := int64(table.firstRecordBlockAddr); addr != nullBlockAddr; n++ {
+			block, err := db.readAt(addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			addr = block.nextBlock()
+		}
+		return n
+	}
+
+	// Short rows share blocks: padded to the declared size, a single one wouldn't fit.
+	if n := blocks(); n > 4 {
+		t.Fatalf("Expected 60 short rows to take at most 4 blocks, got %d", n)
+	}
+
+	condition := NewComparisonExpression(Equal, NewColumnExpression("TITLE"), NewLiteralExpression("doc 7"))
+	if result, err := db.Delete("DOCS", condition); err != nil || result.RowsAffected != 1 {
+		t.Fatalf("Expected to delete the row titled doc 7, got %v (%v)", result, err)
+	}
+	delete(titles, 7)
+
+	// The space of the deleted row is reused.
+	before := blocks()
+	titles[7] = "doc seven"
+	if err := db.Insert("DOCS", map[string]interface{}{"ID": int64(7), "TITLE": titles[7]}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := blocks(); n != before {
+		t.Fatalf("Expected the row to reuse freed space, got %d blocks instead of %d", n, before)
+	}
+
+	// A row growing past the free space of its block no longer fits in it.
+	block, err := db.readAt(int64(table.firstRecordBlockAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rb := table.loadRecordBlockBytes(block)
+	if !table.fits(rb) {
+		t.Fatal("Expected a stored block to fit")
+	}
+
+	rb.dbRecords[0].insertColumnValue(dbChar(strings.Repeat("y", 400)), table.dbColumns[1])
+	if table.fits(rb) {
+		t.Fatal("Expected a full block not to fit a grown row")
+	}
+
+	if db, err = LoadDatabase("varchar.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	table, _ = db.table("DOCS")
+	set, err := db.tableSet(*table)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(set) != len(titles) {
+		t.Fatalf("Expected %d rows, got %d", len(titles), len(set))
+	}
+
+	for _, row := range set {
+		id := int64(row["DOCS.ID"].(dbInteger))
+		if title := stdType(row["DOCS.TITLE"]); title != titles[id] {
+			t.Fatalf("Expected row %d to be titled %v, got %v", id, titles[id], title)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package data
+
+import "context"
+
+/*
+rowChanged is called after insert, update and delete write a row of table. old is nil for
+inserted rows and new is nil for deleted ones. Whatever depends on the contents of the
+table is brought up to date here, as part of the same operation.
+*/
+func (db *Database) rowChanged(ctx context.Context, table dbTable, old dbTuple, new dbTuple) error {
+	operation := ChangeUpdate
+	if old == nil {
+		operation = ChangeInsert
+	} else if new == nil {
+		operation = ChangeDelete
+	}
+	db.recordChange(table, operation, old, new)
+
+	if err := db.maintainMaterializedViews(ctx, table, old, new); err != nil {
+		return err
+	}
+
+	return db.afterTriggers(ctx, table, old, new)
+}
@@ -0,0 +1,263 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/modest-sql/common"
+)
+
+/*
+dbCheck is a CHECK constraint of a table, stored in SYS_CHECKS as a serialized expression.
+A row satisfies it unless the condition evaluates to false; NULL passes, as in standard SQL.
+Column checks may only reference their own column.
+*/
+type dbCheck struct {
+	dbConstraintID dbInteger
+	name           string
+	column         string
+	condition      common.Expression
+}
+
+// CheckConstraint describes a CHECK constraint of a table. ColumnName is empty for table checks.
+type CheckConstraint struct {
+	ConstraintName string
+	ColumnName     string
+	Condition      string
+}
+
+func (db *Database) AddCheckConstraint(tableName string, constraintName string, condition common.Expression) error {
+	return db.AddCheckConstraintContext(context.Background(), tableName, "", constraintName, condition)
+}
+
+func (db *Database) AddColumnCheckConstraint(tableName string, columnName string, constraintName string, condition common.Expression) error {
+	return db.AddCheckConstraintContext(context.Background(), tableName, columnName, constraintName, condition)
+}
+
+/*
+AddCheckConstraintContext attaches condition to the table, or to one of its columns when
+columnName isn't empty, and rejects the rows written from then on that violate it. It fails
+if a row already in the table does. Only the expressions of this package can be stored;
+those of package common can't be inspected and are rejected.
+*/
+func (db *Database) AddCheckConstraintContext(ctx context.Context, tableName string, columnName string, constraintName string, condition common.Expression) error {
+	return db.atomically(func() error {
+		return db.addCheck(ctx, tableName, columnName, constraintName, condition)
+	})
+}
+
+func (db *Database) addCheck(ctx context.Context, tableName string, columnName string, constraintName string, condition common.Expression) error {
+	table, err := db.table(tableName)
+	if err != nil {
+		return err
+	}
+
+	if len(constraintName) > maxNameLength {
+		return fmt.Errorf("Constraint name `%s' can't be longer than %d bytes", constraintName, maxNameLength)
+	}
+
+	if db.constraintExists(constraintName) {
+		return fmt.Errorf("Duplicate constraint `%s' in Database `%s'", constraintName, db.name())
+	}
+
+	if containsSubquery(condition) {
+		return fmt.Errorf("CHECK constraint `%s' can't contain subqueries", constraintName)
+	}
+
+	columns, ok := expressionColumns(condition)
+	if !ok {
+		return fmt.Errorf("CHECK constraint `%s' contains expressions that can't be inspected", constraintName)
+	}
+
+	definition, err := marshalExpression(condition)
+	if err != nil {
+		return err
+	}
+
+	// The check behaves the same before and after LoadDatabase only if it uses the stored form.
+	if condition, err = db.unmarshalExpression(definition); err != nil {
+		return err
+	}
+
+	check := dbCheck{dbConstraintID: db.nextCheckID(), name: constraintName, condition: condition}
+
+	var columnID dbType
+	if columnName != "" {
+		column, err := table.column(columnName)
+		if err != nil {
+			return err
+		}
+		check.column, columnID = column.name(), column.dbColumnID
+	}
+
+	for _, name := range columns {
+		column, err := table.column(name)
+		if err != nil {
+			return err
+		}
+
+		if check.column != "" && column.name() != check.column {
+			return fmt.Errorf("CHECK constraint `%s' of column `%s' references column `%s'", constraintName, check.column, column.name())
+		}
+	}
+
+	if db.checksRecordBlock == nullBlockAddr {
+		if err := db.createSysTable(&db.checksRecordBlock, db.sysChecks()); err != nil {
+			return err
+		}
+	}
+
+	checkName := make(dbChar, maxNameLength)
+	copy(checkName, constraintName)
+
+	for chunk, part := range definitionChunks(definition) {
+		values := map[string]dbType{
+			"CONSTRAINT_ID":   check.dbConstraintID,
+			"TABLE_ID":        table.dbTableID,
+			"COLUMN_ID":       columnID,
+			"CHUNK":           dbInteger(chunk),
+			"CONSTRAINT_NAME": checkName,
+			"DEFINITION":      part,
+		}
+
+		if err := db.insert(ctx, db.sysChecks(), values); err != nil {
+			return err
+		}
+	}
+
+	table.checks = append(table.checks[:len(table.checks):len(table.checks)], check)
+
+	// Rows already in the table must satisfy the new constraint.
+	return db.scanTable(ctx, *table, func(tuple dbTuple) (bool, error) {
+		return true, check.evaluate(*table, tuple)
+	})
+}
+
+// checkRow fails with the name of the first CHECK constraint of the table tuple violates.
+func (t dbTable) checkRow(tuple dbTuple) error {
+	for _, check := range t.checks {
+		if err := check.evaluate(t, tuple); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c dbCheck) evaluate(table dbTable, tuple dbTuple) error {
+	value, err := evaluateBoolean(c.condition, tuple.stdMap())
+	if err != nil {
+		return fmt.Errorf("CHECK constraint `%s' of Table `%s' failed: %v", c.name, table.name(), err)
+	}
+
+	if value != nil && !*value {
+		return fmt.Errorf("Row violates CHECK constraint `%s' of Table `%s'", c.name, table.name())
+	}
+	return nil
+}
+
+func (c dbCheck) public() CheckConstraint {
+	return CheckConstraint{ConstraintName: c.name, ColumnName: c.column, Condition: expressionString(c.condition)}
+}
+
+// constraintExists tells if a UNIQUE or CHECK constraint of any table is called name.
+func (db Database) constraintExists(name string) bool {
+	for _, table := range db.dbTables {
+		for _, unique := range table.uniques {
+			if unique.name == name {
+				return true
+			}
+		}
+
+		for _, check := range table.checks {
+			if check.name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (db Database) sysChecks() dbTable {
+	return newChecksSysTable(dbInteger(db.checksRecordBlock))
+}
+
+func (db Database) nextCheckID() dbInteger {
+	id := dbInteger(0)
+	for _, table := range db.dbTables {
+		for _, check := range table.checks {
+			if check.dbConstraintID > id {
+				id = check.dbConstraintID
+			}
+		}
+	}
+	return id + 1
+}
+
+// loadChecks attaches the constraints stored in SYS_CHECKS to the loaded tables.
+func (db *Database) loadChecks() error {
+	if db.checksRecordBlock == nullBlockAddr {
+		return nil
+	}
+
+	set, err := db.tableSet(db.sysChecks())
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(set, func(i, j int) bool {
+		a, b := set[i], set[j]
+		if a["SYS_CHECKS.CONSTRAINT_ID"].(dbInteger) != b["SYS_CHECKS.CONSTRAINT_ID"].(dbInteger) {
+			return a["SYS_CHECKS.CONSTRAINT_ID"].(dbInteger) < b["SYS_CHECKS.CONSTRAINT_ID"].(dbInteger)
+		}
+		return a["SYS_CHECKS.CHUNK"].(dbInteger) < b["SYS_CHECKS.CHUNK"].(dbInteger)
+	})
+
+	definitions := map[dbInteger]string{}
+	checks := []dbCheck{}
+	tableIDs := map[dbInteger]dbInteger{}
+	columnIDs := map[dbInteger]dbInteger{}
+
+	for _, row := range set {
+		constraintID := row["SYS_CHECKS.CONSTRAINT_ID"].(dbInteger)
+		if _, ok := definitions[constraintID]; !ok {
+			checks = append(checks, dbCheck{dbConstraintID: constraintID, name: trimName(row["SYS_CHECKS.CONSTRAINT_NAME"].(dbChar))})
+			tableIDs[constraintID] = row["SYS_CHECKS.TABLE_ID"].(dbInteger)
+			if columnID, ok := row["SYS_CHECKS.COLUMN_ID"].(dbInteger); ok {
+				columnIDs[constraintID] = columnID
+			}
+		}
+		definitions[constraintID] += trimName(row["SYS_CHECKS.DEFINITION"].(dbChar))
+	}
+
+	for _, check := range checks {
+		if check.condition, err = db.unmarshalExpression(definitions[check.dbConstraintID]); err != nil {
+			return fmt.Errorf("CHECK constraint `%s' is invalid: %v", check.name, err)
+		}
+
+		for i := range db.dbTables {
+			table := &db.dbTables[i]
+			if table.dbTableID != tableIDs[check.dbConstraintID] {
+				continue
+			}
+
+			for _, column := range table.dbColumns {
+				if columnID, ok := columnIDs[check.dbConstraintID]; ok && column.dbColumnID == columnID {
+					check.column = column.name()
+				}
+			}
+			table.checks = append(table.checks, check)
+		}
+	}
+
+	return nil
+}
+
+// dropChecks deletes the SYS_CHECKS rows of a table.
+func (db *Database) dropChecks(ctx context.Context, table dbTable) error {
+	if db.checksRecordBlock == nullBlockAddr {
+		return nil
+	}
+
+	return db.delete(ctx, db.sysChecks(), dropCondition("SYS_CHECKS", "TABLE_ID", int64(table.dbTableID)), nil)
+}
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/modest-sql/common"
@@ -14,14 +15,18 @@ const (
 	dbAutoincrementConstraint
 	dbNotNullConstraint
 	dbDefaultValueConstraint
+	dbUniqueConstraint
+	dbSequenceDefaultConstraint
 )
 
 var dbConstraintTypeNames = map[dbConstraintType]string{
-	dbPrimaryKeyConstraint:    "PRIMARY_KEY",
-	dbForeignKeyConstraint:    "FOREIGN_KEY",
-	dbAutoincrementConstraint: "AUTOINCREMENT",
-	dbNotNullConstraint:       "NOT_NULL",
-	dbDefaultValueConstraint:  "DEFAULT_VALUE",
+	dbPrimaryKeyConstraint:      "PRIMARY_KEY",
+	dbForeignKeyConstraint:      "FOREIGN_KEY",
+	dbAutoincrementConstraint:   "AUTOINCREMENT",
+	dbNotNullConstraint:         "NOT_NULL",
+	dbDefaultValueConstraint:    "DEFAULT_VALUE",
+	dbUniqueConstraint:          "UNIQUE",
+	dbSequenceDefaultConstraint: "DEFAULT_SEQUENCE",
 }
 
 type dbColumn struct {
@@ -37,7 +42,7 @@ type dbColumn struct {
 	dbConstraints              dbConstraintType
 }
 
-func (db *Database) newDBColumn(table dbTable, definition common.TableColumnDefiner, pos int) (column dbColumn, err error) {
+func (db *Database) newDBColumn(ctx context.Context, table dbTable, definition common.TableColumnDefiner, pos int) (column dbColumn, err error) {
 	columnID := db.columns + 1
 
 	columnName := make(dbChar, maxNameLength)
@@ -57,6 +62,11 @@ func (db *Database) newDBColumn(table dbTable, definition common.TableColumnDefi
 		typeID, typeSize = dbBooleanTypeID, dbBooleanSize
 	case common.CharTableColumn:
 		typeID, typeSize = dbCharTypeID, dbInteger(v.Size())
+	case VarcharTableColumn:
+		if v.Size() == 0 || v.Size() > maxVarcharLength {
+			return dbColumn{}, fmt.Errorf("Size of VARCHAR column `%s' must be between 1 and %d", definition.ColumnName(), maxVarcharLength)
+		}
+		typeID, typeSize = dbVarcharTypeID, dbInteger(v.Size())
 	}
 
 	column = dbColumn{
@@ -70,14 +80,25 @@ func (db *Database) newDBColumn(table dbTable, definition common.TableColumnDefi
 	}
 
 	var defaultID dbInteger
-	if definition.DefaultValue() != nil {
+	if reference, ok := definition.DefaultValue().(SequenceReference); ok {
+		column.addConstraint(dbDefaultValueConstraint)
+		column.addConstraint(dbSequenceDefaultConstraint)
+
+		if defaultID, err = db.newSequenceDefault(ctx, column, reference); err != nil {
+			return dbColumn{}, err
+		}
+	} else if definition.DefaultValue() != nil {
 		column.addConstraint(dbDefaultValueConstraint)
 
 		value := castDBType(definition)
-		if _, ok := definition.(common.CharTableColumn); ok {
-			defaultID, err = db.newDefaultChar(value)
+		if textType(typeID) {
+			// SYS_DEFAULT_CHARS stores a default in a single CHAR(maxCharLength) value.
+			if len(value.bytes()) > maxCharLength {
+				return dbColumn{}, fmt.Errorf("Default value of column `%s' can't be longer than %d bytes", definition.ColumnName(), maxCharLength)
+			}
+			defaultID, err = db.newDefaultChar(ctx, value)
 		} else {
-			defaultID, err = db.newDefaultNumeric(value)
+			defaultID, err = db.newDefaultNumeric(ctx, value)
 		}
 
 		if err != nil {
@@ -146,7 +167,7 @@ func (dc *dbColumn) increment() error {
 	return nil
 }
 
-func (db *Database) newDefaultNumeric(value dbType) (dbInteger, error) {
+func (db *Database) newDefaultNumeric(ctx context.Context, value dbType) (dbInteger, error) {
 	defaultID := dbInteger(db.defaultNumerics + 1)
 
 	values := map[string]dbType{
@@ -154,7 +175,7 @@ func (db *Database) newDefaultNumeric(value dbType) (dbInteger, error) {
 		"VALUE":    value,
 	}
 
-	if err := db.insert(db.sysNumerics(), values); err != nil {
+	if err := db.insert(ctx, db.sysNumerics(), values); err != nil {
 		return 0, err
 	}
 
@@ -165,7 +186,7 @@ func (db *Database) newDefaultNumeric(value dbType) (dbInteger, error) {
 	return defaultID, nil
 }
 
-func (db *Database) newDefaultChar(value dbType) (dbInteger, error) {
+func (db *Database) newDefaultChar(ctx context.Context, value dbType) (dbInteger, error) {
 	defaultID := dbInteger(db.defaultChars + 1)
 
 	tmp := make(dbChar, maxCharLength)
@@ -176,7 +197,7 @@ func (db *Database) newDefaultChar(value dbType) (dbInteger, error) {
 		"VALUE":    tmp,
 	}
 
-	if err := db.insert(db.sysChars(), values); err != nil {
+	if err := db.insert(ctx, db.sysChars(), values); err != nil {
 		return 0, err
 	}
 
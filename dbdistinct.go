@@ -0,0 +1,226 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+)
+
+const (
+	nullValueMarker byte = 0xFF
+)
+
+/*
+distinctFilter eliminates duplicate tuples. Unique tuples are kept in an in-memory hash set
+until it holds capacity entries; tuples that can't be decided in memory are spilled to
+partition files on disk and deduplicated partition by partition on flush.
+*/
+type distinctFilter struct {
+	capacity   int
+	level      byte
+	seen       map[string]struct{}
+	partitions []*os.File
+	writers    []*bufio.Writer
+}
+
+func newDistinctFilter(capacity int) *distinctFilter {
+	return &distinctFilter{capacity: capacity, seen: map[string]struct{}{}}
+}
+
+// add reports whether tuple must be emitted now. Duplicates and spilled tuples return false.
+func (f *distinctFilter) add(tuple dbTuple) (bool, error) {
+	key := tupleKey(tuple)
+	if _, ok := f.seen[key]; ok {
+		return false, nil
+	}
+
+	if len(f.seen) < f.capacity {
+		f.seen[key] = struct{}{}
+		return true, nil
+	}
+
+	return false, f.spill(key, tuple)
+}
+
+func (f *distinctFilter) spill(key string, tuple dbTuple) error {
+	if f.partitions == nil {
+		for i := 0; i < distinctPartitions; i++ {
+			file, err := ioutil.TempFile("", "distinct")
+			if err != nil {
+				return err
+			}
+
+			f.partitions = append(f.partitions, file)
+			f.writers = append(f.writers, bufio.NewWriter(file))
+		}
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte{f.level})
+	h.Write([]byte(key))
+
+	return writeSpilledTuple(f.writers[h.Sum32()%distinctPartitions], tuple)
+}
+
+// flush emits the unique spilled tuples, stopping early when emit returns false.
+func (f *distinctFilter) flush(emit func(dbTuple) (bool, error)) error {
+	for i := range f.partitions {
+		if err := f.writers[i].Flush(); err != nil {
+			return err
+		}
+
+		if _, err := f.partitions[i].Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		partition := newDistinctFilter(f.capacity)
+		partition.level = f.level + 1
+
+		more, err := partition.drain(bufio.NewReader(f.partitions[i]), emit)
+		if err == nil && more {
+			err = partition.flush(emit)
+		}
+		partition.close()
+
+		if err != nil || !more {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *distinctFilter) drain(r io.Reader, emit func(dbTuple) (bool, error)) (bool, error) {
+	for {
+		tuple, err := readSpilledTuple(r)
+		if err == io.EOF {
+			return true, nil
+		} else if err != nil {
+			return false, err
+		}
+
+		unique, err := f.add(tuple)
+		if err != nil {
+			return false, err
+		}
+
+		if unique {
+			if more, err := emit(tuple); err != nil || !more {
+				return false, err
+			}
+		}
+	}
+}
+
+func (f *distinctFilter) close() {
+	for _, file := range f.partitions {
+		file.Close()
+		os.Remove(file.Name())
+	}
+
+	f.partitions, f.writers = nil, nil
+}
+
+/*
+tupleKey returns a string that is equal for two tuples if and only if both have the same
+columns holding equal values. CHAR values are compared without their padding and NULLs
+are only equal to other NULLs.
+*/
+func tupleKey(tuple dbTuple) string {
+	normalized := dbTuple{}
+	for name, value := range tuple {
+		switch v := value.(type) {
+		case dbChar:
+			value = dbChar(bytes.TrimRight(v, "\x00"))
+		case dbFloat:
+			// Values that compare equal share a key: 0 and -0, and every NaN.
+			if v == 0 {
+				value = dbFloat(0)
+			} else if math.IsNaN(float64(v)) {
+				value = dbFloat(math.NaN())
+			}
+		}
+		normalized[name] = value
+	}
+
+	return string(encodeTuple(normalized))
+}
+
+func encodeTuple(tuple dbTuple) []byte {
+	names := []string{}
+	for name := range tuple {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	for _, name := range names {
+		binary.Write(&b, binary.LittleEndian, uint16(len(name)))
+		b.WriteString(name)
+
+		value := tuple[name]
+		if value == nil {
+			b.WriteByte(nullValueMarker)
+			continue
+		}
+
+		b.WriteByte(byte(value.dbTypeID()))
+		binary.Write(&b, binary.LittleEndian, uint32(len(value.bytes())))
+		b.Write(value.bytes())
+	}
+
+	return b.Bytes()
+}
+
+func decodeTuple(b []byte) dbTuple {
+	tuple := dbTuple{}
+
+	for len(b) > 0 {
+		nameLength := int(binary.LittleEndian.Uint16(b))
+		name := string(b[2 : 2+nameLength])
+		b = b[2+nameLength:]
+
+		typeID := b[0]
+		b = b[1:]
+		if typeID == nullValueMarker {
+			tuple[name] = nil
+			continue
+		}
+
+		valueLength := int(binary.LittleEndian.Uint32(b))
+		tuple[name] = loadDBType(dbTypeID(typeID), append([]byte{}, b[4:4+valueLength]...))
+		b = b[4+valueLength:]
+	}
+
+	return tuple
+}
+
+func writeSpilledTuple(w io.Writer, tuple dbTuple) error {
+	b := encodeTuple(tuple)
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(b))); err != nil {
+		return err
+	}
+
+	_, err := w.Write(b)
+	return err
+}
+
+func readSpilledTuple(r io.Reader) (dbTuple, error) {
+	var length uint32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+
+	return decodeTuple(b), nil
+}
@@ -0,0 +1,175 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/modest-sql/common"
+)
+
+// PlanNode describes an operator of the plan used to execute a select command.
+type PlanNode struct {
+	Operator      string
+	Detail        string
+	EstimatedRows int64
+	Analyzed      bool
+	Rows          int64
+	BlocksRead    int64
+	Duration      time.Duration
+	Children      []*PlanNode
+}
+
+// String formats the plan as an indented tree, one operator per line.
+func (n *PlanNode) String() string {
+	var b bytes.Buffer
+	n.format(&b, 0)
+	return b.String()
+}
+
+func (n *PlanNode) format(b *bytes.Buffer, depth int) {
+	b.WriteString(strings.Repeat("  ", depth))
+	b.WriteString(n.Operator)
+	if n.Detail != "" {
+		fmt.Fprintf(b, " %s", n.Detail)
+	}
+
+	fmt.Fprintf(b, " (rows=%d)", n.EstimatedRows)
+	if n.Analyzed {
+		fmt.Fprintf(b, " (actual rows=%d blocks=%d time=%v)", n.Rows, n.BlocksRead, n.Duration)
+	}
+	b.WriteString("\n")
+
+	for _, child := range n.Children {
+		child.format(b, depth+1)
+	}
+}
+
+/*
+ExplainCommand requests the plan of a select command instead of its result. When analyze
+is set the select is executed and the plan carries the actual figures of every operator.
+*/
+type ExplainCommand struct {
+	selectCommand *common.SelectTableCommand
+	analyze       bool
+}
+
+func NewExplainCommand(cmd *common.SelectTableCommand, analyze bool) *ExplainCommand {
+	return &ExplainCommand{selectCommand: cmd, analyze: analyze}
+}
+
+func (c *ExplainCommand) SelectCommand() *common.SelectTableCommand {
+	return c.selectCommand
+}
+
+func (c *ExplainCommand) Analyze() bool {
+	return c.analyze
+}
+
+func (db *Database) Explain(cmd *common.SelectTableCommand, options ...SelectOption) (*PlanNode, error) {
+	return db.ExplainContext(context.Background(), cmd, options...)
+}
+
+// ExplainContext returns the plan Select would use for cmd without executing it.
+func (db *Database) ExplainContext(ctx context.Context, cmd *common.SelectTableCommand, options ...SelectOption) (*PlanNode, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	query, err := newSelectQuery(cmd, options)
+	if err != nil {
+		return nil, err
+	}
+
+	resetSubqueries(ctx, query.expressions()...)
+	plan, err := db.plan(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return describePlan(plan), nil
+}
+
+// ExplainAnalyze executes cmd and returns its plan with the rows, blocks read and time of each operator.
+func (db *Database) ExplainAnalyze(cmd *common.SelectTableCommand, options ...SelectOption) (*PlanNode, error) {
+	return db.ExplainAnalyzeContext(context.Background(), cmd, options...)
+}
+
+func (db *Database) ExplainAnalyzeContext(ctx context.Context, cmd *common.SelectTableCommand, options ...SelectOption) (*PlanNode, error) {
+	query, err := newSelectQuery(cmd, options)
+	if err != nil {
+		return nil, err
+	}
+
+	resetSubqueries(ctx, query.expressions()...)
+	plan, err := db.plan(query)
+	if err != nil {
+		return nil, err
+	}
+
+	plan = instrumentPlan(plan)
+	if err := plan.run(ctx, db, func(dbTuple) (bool, error) { return true, nil }); err != nil {
+		return nil, err
+	}
+
+	return describePlan(plan), nil
+}
+
+/*
+analyzedNode wraps a plan node to measure it. Since nodes push tuples to their parent,
+the time spent by the parent consuming them is excluded from the node's time.
+*/
+type analyzedNode struct {
+	planNode
+	rows     int64
+	duration time.Duration
+}
+
+func instrumentPlan(node planNode) planNode {
+	for _, input := range node.inputs() {
+		*input = instrumentPlan(*input)
+	}
+
+	return &analyzedNode{planNode: node}
+}
+
+func (n *analyzedNode) run(ctx context.Context, db *Database, emit tupleEmitter) error {
+	start := time.Now()
+	var consumer time.Duration
+
+	err := n.planNode.run(ctx, db, func(tuple dbTuple) (bool, error) {
+		n.rows++
+
+		consumerStart := time.Now()
+		more, err := emit(tuple)
+		consumer += time.Since(consumerStart)
+		return more, err
+	})
+
+	n.duration += time.Since(start) - consumer
+	return err
+}
+
+func describePlan(node planNode) *PlanNode {
+	description := &PlanNode{EstimatedRows: node.estimate()}
+
+	if analyzed, ok := node.(*analyzedNode); ok {
+		description.Analyzed = true
+		description.Rows = analyzed.rows
+		description.Duration = analyzed.duration
+		node = analyzed.planNode
+	}
+
+	if scan, ok := node.(*scanNode); ok {
+		description.BlocksRead = scan.blocksRead
+	}
+
+	description.Operator, description.Detail = node.explain()
+	for _, input := range node.inputs() {
+		description.Children = append(description.Children, describePlan(*input))
+	}
+
+	return description
+}
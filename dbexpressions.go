@@ -0,0 +1,816 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/modest-sql/common"
+)
+
+/*
+The expressions in this file implement common.Expression so they can be used anywhere a
+condition or a computed column is expected. Evaluate returns nil for SQL NULL and an
+error value when the expression can't be evaluated.
+*/
+
+// ColumnExpression evaluates to the value of a column of the current row.
+type ColumnExpression struct {
+	name string
+}
+
+// NewColumnExpression references a column by its qualified (TABLE.COLUMN) or unqualified name.
+func NewColumnExpression(name string) *ColumnExpression {
+	return &ColumnExpression{name: name}
+}
+
+func (e *ColumnExpression) Evaluate(symbols map[string]interface{}) interface{} {
+	if value, ok := symbols[e.name]; ok {
+		return value
+	}
+
+	if tableName, _ := splitIdentifier(e.name); tableName != "" {
+		return fmt.Errorf("Column `%s' does not exist", e.name)
+	}
+
+	var value interface{}
+	matches := 0
+	for key := range symbols {
+		if _, columnName := splitIdentifier(key); columnName == e.name {
+			value = symbols[key]
+			matches++
+		}
+	}
+
+	switch matches {
+	case 0:
+		return fmt.Errorf("Column `%s' does not exist", e.name)
+	case 1:
+		return value
+	}
+
+	return fmt.Errorf("Column `%s' is ambiguous", e.name)
+}
+
+// LiteralExpression evaluates to a constant value.
+type LiteralExpression struct {
+	value interface{}
+}
+
+// NewLiteralExpression creates a constant of type int64, float64, bool or string, or NULL when value is nil.
+func NewLiteralExpression(value interface{}) *LiteralExpression {
+	return &LiteralExpression{value: value}
+}
+
+func (e *LiteralExpression) Evaluate(symbols map[string]interface{}) interface{} {
+	return e.value
+}
+
+type ArithmeticOperator byte
+
+const (
+	Addition       ArithmeticOperator = '+'
+	Subtraction    ArithmeticOperator = '-'
+	Multiplication ArithmeticOperator = '*'
+	Division       ArithmeticOperator = '/'
+	Modulo         ArithmeticOperator = '%'
+)
+
+// ArithmeticExpression applies an arithmetic operator to two numeric operands.
+type ArithmeticExpression struct {
+	operator    ArithmeticOperator
+	left, right common.Expression
+}
+
+func NewArithmeticExpression(operator ArithmeticOperator, left common.Expression, right common.Expression) *ArithmeticExpression {
+	return &ArithmeticExpression{operator: operator, left: left, right: right}
+}
+
+func (e *ArithmeticExpression) Evaluate(symbols map[string]interface{}) interface{} {
+	values, err := evaluateOperands(symbols, e.left, e.right)
+	if err != nil || values == nil {
+		return err
+	}
+
+	a, aIsInt := values[0].(int64)
+	b, bIsInt := values[1].(int64)
+	if aIsInt && bIsInt {
+		switch e.operator {
+		case Addition:
+			return a + b
+		case Subtraction:
+			return a - b
+		case Multiplication:
+			return a * b
+		case Division, Modulo:
+			if b == 0 {
+				return errors.New("Division by zero")
+			}
+
+			if e.operator == Division {
+				return a / b
+			}
+			return a % b
+		}
+
+		return fmt.Errorf("Unknown arithmetic operator `%c'", e.operator)
+	}
+
+	x, ok := toFloat(values[0])
+	if !ok {
+		return fmt.Errorf("Invalid %T operand for operator `%c'", values[0], e.operator)
+	}
+
+	y, ok := toFloat(values[1])
+	if !ok {
+		return fmt.Errorf("Invalid %T operand for operator `%c'", values[1], e.operator)
+	}
+
+	switch e.operator {
+	case Addition:
+		return x + y
+	case Subtraction:
+		return x - y
+	case Multiplication:
+		return x * y
+	case Division:
+		if y == 0 {
+			return errors.New("Division by zero")
+		}
+		return x / y
+	}
+
+	return fmt.Errorf("Invalid FLOAT operands for operator `%c'", e.operator)
+}
+
+// ConcatExpression concatenates the string representation of two operands.
+type ConcatExpression struct {
+	left, right common.Expression
+}
+
+func NewConcatExpression(left common.Expression, right common.Expression) *ConcatExpression {
+	return &ConcatExpression{left: left, right: right}
+}
+
+func (e *ConcatExpression) Evaluate(symbols map[string]interface{}) interface{} {
+	values, err := evaluateOperands(symbols, e.left, e.right)
+	if err != nil || values == nil {
+		return err
+	}
+
+	return fmt.Sprint(values[0]) + fmt.Sprint(values[1])
+}
+
+type function func(args []interface{}) interface{}
+
+var functions = map[string]function{
+	"UPPER":    stringFunction(strings.ToUpper),
+	"LOWER":    stringFunction(strings.ToLower),
+	"TRIM":     stringFunction(strings.TrimSpace),
+	"LENGTH":   lengthFunction,
+	"ABS":      absFunction,
+	"COALESCE": coalesceFunction,
+	"CONCAT":   concatFunction,
+}
+
+// FunctionExpression calls one of the built-in scalar functions.
+type FunctionExpression struct {
+	name      string
+	function  function
+	arguments []common.Expression
+}
+
+/*
+NewFunctionExpression creates a call to a built-in function. The supported functions are
+UPPER, LOWER, TRIM, LENGTH, ABS, COALESCE and CONCAT.
+*/
+func NewFunctionExpression(name string, arguments ...common.Expression) (*FunctionExpression, error) {
+	name = strings.ToUpper(name)
+
+	f, ok := functions[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown function `%s'", name)
+	}
+
+	return &FunctionExpression{name: name, function: f, arguments: arguments}, nil
+}
+
+func (e *FunctionExpression) Evaluate(symbols map[string]interface{}) interface{} {
+	args := []interface{}{}
+	for _, argument := range e.arguments {
+		value := argument.Evaluate(symbols)
+		if err, ok := value.(error); ok {
+			return err
+		}
+		args = append(args, value)
+	}
+
+	result := e.function(args)
+	if err, ok := result.(error); ok {
+		return fmt.Errorf("%s: %v", e.name, err)
+	}
+
+	return result
+}
+
+func stringFunction(f func(string) string) function {
+	return func(args []interface{}) interface{} {
+		if len(args) != 1 {
+			return errors.New("Expected 1 argument")
+		}
+
+		if args[0] == nil {
+			return nil
+		}
+
+		s, ok := args[0].(string)
+		if !ok {
+			return fmt.Errorf("Invalid %T argument", args[0])
+		}
+
+		return f(s)
+	}
+}
+
+func lengthFunction(args []interface{}) interface{} {
+	if len(args) != 1 {
+		return errors.New("Expected 1 argument")
+	}
+
+	if args[0] == nil {
+		return nil
+	}
+
+	s, ok := args[0].(string)
+	if !ok {
+		return fmt.Errorf("Invalid %T argument", args[0])
+	}
+
+	return int64(len(s))
+}
+
+func absFunction(args []interface{}) interface{} {
+	if len(args) != 1 {
+		return errors.New("Expected 1 argument")
+	}
+
+	switch v := args[0].(type) {
+	case nil:
+		return nil
+	case int64:
+		if v < 0 {
+			return -v
+		}
+		return v
+	case float64:
+		if v < 0 {
+			return -v
+		}
+		return v
+	}
+
+	return fmt.Errorf("Invalid %T argument", args[0])
+}
+
+func coalesceFunction(args []interface{}) interface{} {
+	for _, arg := range args {
+		if arg != nil {
+			return arg
+		}
+	}
+
+	return nil
+}
+
+func concatFunction(args []interface{}) interface{} {
+	var s string
+	for _, arg := range args {
+		if arg != nil {
+			s += fmt.Sprint(arg)
+		}
+	}
+
+	return s
+}
+
+/*
+evaluateOperands evaluates every operand. It returns a nil slice when any operand is NULL,
+since NULL propagates through arithmetic and concatenation.
+*/
+func evaluateOperands(symbols map[string]interface{}, operands ...common.Expression) ([]interface{}, error) {
+	values := []interface{}{}
+	null := false
+
+	for _, operand := range operands {
+		value := operand.Evaluate(symbols)
+		if err, ok := value.(error); ok {
+			return nil, err
+		}
+
+		null = null || value == nil
+		values = append(values, value)
+	}
+
+	if null {
+		return nil, nil
+	}
+
+	return values, nil
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+
+	return 0, false
+}
+
+// ExpressionSelector projects the result of an expression under an alias.
+type ExpressionSelector struct {
+	expression common.Expression
+	alias      string
+}
+
+// NewExpressionSelector creates a projected column holding expression, named alias in the result.
+func NewExpressionSelector(expression common.Expression, alias string) *ExpressionSelector {
+	return &ExpressionSelector{expression: expression, alias: alias}
+}
+
+func (s *ExpressionSelector) Alias() string {
+	return s.alias
+}
+
+func (s *ExpressionSelector) Expression() common.Expression {
+	return s.expression
+}
+
+type ComparisonOperator uint8
+
+const (
+	Equal ComparisonOperator = iota
+	NotEqual
+	Less
+	LessOrEqual
+	Greater
+	GreaterOrEqual
+)
+
+var comparisonOperatorNames = map[ComparisonOperator]string{
+	Equal:          "=",
+	NotEqual:       "<>",
+	Less:           "<",
+	LessOrEqual:    "<=",
+	Greater:        ">",
+	GreaterOrEqual: ">=",
+}
+
+// ComparisonExpression compares two operands of compatible types.
+type ComparisonExpression struct {
+	operator    ComparisonOperator
+	left, right common.Expression
+}
+
+func NewComparisonExpression(operator ComparisonOperator, left common.Expression, right common.Expression) *ComparisonExpression {
+	return &ComparisonExpression{operator: operator, left: left, right: right}
+}
+
+func (e *ComparisonExpression) Evaluate(symbols map[string]interface{}) interface{} {
+	values, err := evaluateOperands(symbols, e.left, e.right)
+	if err != nil || values == nil {
+		return err
+	}
+
+	c, err := compareValues(values[0], values[1])
+	if err != nil {
+		return err
+	}
+
+	switch e.operator {
+	case Equal:
+		return c == 0
+	case NotEqual:
+		return c != 0
+	case Less:
+		return c < 0
+	case LessOrEqual:
+		return c <= 0
+	case Greater:
+		return c > 0
+	case GreaterOrEqual:
+		return c >= 0
+	}
+
+	return fmt.Errorf("Unknown comparison operator %d", e.operator)
+}
+
+func (e *ComparisonExpression) operands() []common.Expression {
+	return []common.Expression{e.left, e.right}
+}
+
+// AndExpression is the conjunction of two conditions, following SQL three-valued logic.
+type AndExpression struct {
+	left, right common.Expression
+}
+
+func NewAndExpression(left common.Expression, right common.Expression) *AndExpression {
+	return &AndExpression{left: left, right: right}
+}
+
+func (e *AndExpression) Evaluate(symbols map[string]interface{}) interface{} {
+	a, err := evaluateBoolean(e.left, symbols)
+	if err != nil {
+		return err
+	}
+
+	if a != nil && !*a {
+		return false
+	}
+
+	b, err := evaluateBoolean(e.right, symbols)
+	if err != nil {
+		return err
+	}
+
+	if b != nil && !*b {
+		return false
+	}
+
+	if a == nil || b == nil {
+		return nil
+	}
+
+	return true
+}
+
+func (e *AndExpression) operands() []common.Expression {
+	return []common.Expression{e.left, e.right}
+}
+
+// OrExpression is the disjunction of two conditions, following SQL three-valued logic.
+type OrExpression struct {
+	left, right common.Expression
+}
+
+func NewOrExpression(left common.Expression, right common.Expression) *OrExpression {
+	return &OrExpression{left: left, right: right}
+}
+
+func (e *OrExpression) Evaluate(symbols map[string]interface{}) interface{} {
+	a, err := evaluateBoolean(e.left, symbols)
+	if err != nil {
+		return err
+	}
+
+	if a != nil && *a {
+		return true
+	}
+
+	b, err := evaluateBoolean(e.right, symbols)
+	if err != nil {
+		return err
+	}
+
+	if b != nil && *b {
+		return true
+	}
+
+	if a == nil || b == nil {
+		return nil
+	}
+
+	return false
+}
+
+func (e *OrExpression) operands() []common.Expression {
+	return []common.Expression{e.left, e.right}
+}
+
+// NotExpression negates a condition. The negation of NULL is NULL.
+type NotExpression struct {
+	operand common.Expression
+}
+
+func NewNotExpression(operand common.Expression) *NotExpression {
+	return &NotExpression{operand: operand}
+}
+
+func (e *NotExpression) Evaluate(symbols map[string]interface{}) interface{} {
+	a, err := evaluateBoolean(e.operand, symbols)
+	if err != nil {
+		return err
+	}
+
+	if a == nil {
+		return nil
+	}
+
+	return !*a
+}
+
+func (e *NotExpression) operands() []common.Expression {
+	return []common.Expression{e.operand}
+}
+
+// IsNullExpression evaluates to true when its operand is NULL, or the opposite when negated.
+type IsNullExpression struct {
+	operand common.Expression
+	negated bool
+}
+
+func NewIsNullExpression(operand common.Expression, negated bool) *IsNullExpression {
+	return &IsNullExpression{operand: operand, negated: negated}
+}
+
+func (e *IsNullExpression) Evaluate(symbols map[string]interface{}) interface{} {
+	value := e.operand.Evaluate(symbols)
+	if err, ok := value.(error); ok {
+		return err
+	}
+
+	return (value == nil) != e.negated
+}
+
+func (e *IsNullExpression) operands() []common.Expression {
+	return []common.Expression{e.operand}
+}
+
+/*
+compositeExpression is implemented by the expressions of this package that are built
+from other expressions, so the planner can walk an expression tree.
+*/
+type compositeExpression interface {
+	operands() []common.Expression
+}
+
+func (e *ArithmeticExpression) operands() []common.Expression {
+	return []common.Expression{e.left, e.right}
+}
+
+func (e *ConcatExpression) operands() []common.Expression {
+	return []common.Expression{e.left, e.right}
+}
+
+func (e *FunctionExpression) operands() []common.Expression {
+	return e.arguments
+}
+
+/*
+expressionColumns returns the names of the columns referenced by expr. The second result
+is false when expr contains expressions that can't be inspected, like those of package common.
+*/
+func expressionColumns(expr common.Expression) ([]string, bool) {
+	switch e := expr.(type) {
+	case *ColumnExpression:
+		return []string{e.name}, true
+	case *LiteralExpression:
+		return nil, true
+	case *SubqueryExpression:
+		columns := append([]string{}, e.outer...)
+		if e.operand != nil {
+			operandColumns, ok := expressionColumns(e.operand)
+			if !ok {
+				return nil, false
+			}
+			columns = append(columns, operandColumns...)
+		}
+		return columns, true
+	case compositeExpression:
+		columns := []string{}
+		for _, operand := range e.operands() {
+			operandColumns, ok := expressionColumns(operand)
+			if !ok {
+				return nil, false
+			}
+			columns = append(columns, operandColumns...)
+		}
+		return columns, true
+	}
+
+	return nil, false
+}
+
+// referencedColumns returns the columns referenced by the parts of expr that can be inspected.
+func referencedColumns(expr common.Expression) (columns []string) {
+	switch e := expr.(type) {
+	case *ColumnExpression:
+		return []string{e.name}
+	case *SubqueryExpression:
+		columns = append(columns, e.outer...)
+	}
+
+	if composite, ok := expr.(compositeExpression); ok {
+		for _, operand := range composite.operands() {
+			columns = append(columns, referencedColumns(operand)...)
+		}
+	}
+	return columns
+}
+
+/*
+rewriteExpression rebuilds expr bottom-up, replacing every subexpression by the result of
+fn. Expressions that can't be inspected are passed to fn as a whole.
+*/
+func rewriteExpression(expr common.Expression, fn func(common.Expression) common.Expression) common.Expression {
+	rewrite := func(operand common.Expression) common.Expression {
+		return rewriteExpression(operand, fn)
+	}
+
+	switch e := expr.(type) {
+	case *ArithmeticExpression:
+		expr = NewArithmeticExpression(e.operator, rewrite(e.left), rewrite(e.right))
+	case *ConcatExpression:
+		expr = NewConcatExpression(rewrite(e.left), rewrite(e.right))
+	case *FunctionExpression:
+		arguments := []common.Expression{}
+		for _, argument := range e.arguments {
+			arguments = append(arguments, rewrite(argument))
+		}
+		expr = &FunctionExpression{name: e.name, function: e.function, arguments: arguments}
+	case *ComparisonExpression:
+		expr = NewComparisonExpression(e.operator, rewrite(e.left), rewrite(e.right))
+	case *AndExpression:
+		expr = NewAndExpression(rewrite(e.left), rewrite(e.right))
+	case *OrExpression:
+		expr = NewOrExpression(rewrite(e.left), rewrite(e.right))
+	case *NotExpression:
+		expr = NewNotExpression(rewrite(e.operand))
+	case *IsNullExpression:
+		expr = NewIsNullExpression(rewrite(e.operand), e.negated)
+	case *SubqueryExpression:
+		if e.operand != nil {
+			subquery := *e
+			subquery.operand = rewrite(e.operand)
+			expr = &subquery
+		}
+	}
+
+	return fn(expr)
+}
+
+// conjuncts splits expr into the conditions that must all hold for expr to hold.
+func conjuncts(expr common.Expression) []common.Expression {
+	if expr == nil {
+		return nil
+	}
+
+	if and, ok := expr.(*AndExpression); ok {
+		return append(conjuncts(and.left), conjuncts(and.right)...)
+	}
+
+	return []common.Expression{expr}
+}
+
+func evaluateBoolean(expr common.Expression, symbols map[string]interface{}) (*bool, error) {
+	switch v := expr.Evaluate(symbols).(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		return &v, nil
+	case error:
+		return nil, v
+	}
+
+	return nil, errors.New("Condition does not evaluate to a BOOLEAN value")
+}
+
+// evaluatePredicate reports whether condition holds for symbols. A NULL condition does not hold.
+func evaluatePredicate(condition common.Expression, symbols map[string]interface{}) (bool, error) {
+	value, err := evaluateBoolean(condition, symbols)
+	if err != nil || value == nil {
+		return false, err
+	}
+
+	return *value, nil
+}
+
+func compareValues(a interface{}, b interface{}) (int, error) {
+	switch x := a.(type) {
+	case int64:
+		if y, ok := b.(int64); ok {
+			return compareInts(x, y), nil
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), nil
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			return compareBools(x, y), nil
+		}
+	}
+
+	x, xok := toFloat(a)
+	y, yok := toFloat(b)
+	if xok && yok {
+		return compareFloats(x, y), nil
+	}
+
+	return 0, fmt.Errorf("Can't compare %T with %T", a, b)
+}
+
+func compareBools(a bool, b bool) int {
+	if a == b {
+		return 0
+	} else if b {
+		return -1
+	}
+	return 1
+}
+
+func compareInts(a int64, b int64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+// compareFloats orders NaN after every other value and equal to itself, as PostgreSQL does.
+func compareFloats(a float64, b float64) int {
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return compareBools(math.IsNaN(a), math.IsNaN(b))
+	}
+
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func (e *ColumnExpression) String() string {
+	return e.name
+}
+
+func (e *LiteralExpression) String() string {
+	switch v := e.value.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return "'" + strings.Replace(v, "'", "''", -1) + "'"
+	case bool:
+		return strings.ToUpper(fmt.Sprint(v))
+	}
+	return fmt.Sprint(e.value)
+}
+
+func (e *ArithmeticExpression) String() string {
+	return fmt.Sprintf("(%s %c %s)", expressionString(e.left), e.operator, expressionString(e.right))
+}
+
+func (e *ConcatExpression) String() string {
+	return fmt.Sprintf("(%s || %s)", expressionString(e.left), expressionString(e.right))
+}
+
+func (e *FunctionExpression) String() string {
+	return fmt.Sprintf("%s(%s)", e.name, strings.Join(expressionStrings(e.arguments), ", "))
+}
+
+func (e *ComparisonExpression) String() string {
+	return fmt.Sprintf("%s %s %s", expressionString(e.left), comparisonOperatorNames[e.operator], expressionString(e.right))
+}
+
+func (e *AndExpression) String() string {
+	return fmt.Sprintf("(%s AND %s)", expressionString(e.left), expressionString(e.right))
+}
+
+func (e *OrExpression) String() string {
+	return fmt.Sprintf("(%s OR %s)", expressionString(e.left), expressionString(e.right))
+}
+
+func (e *NotExpression) String() string {
+	return fmt.Sprintf("NOT %s", expressionString(e.operand))
+}
+
+func (e *IsNullExpression) String() string {
+	if e.negated {
+		return fmt.Sprintf("%s IS NOT NULL", expressionString(e.operand))
+	}
+	return fmt.Sprintf("%s IS NULL", expressionString(e.operand))
+}
+
+// expressionString formats expr, falling back to its type for expressions that can't be printed.
+func expressionString(expr common.Expression) string {
+	if stringer, ok := expr.(fmt.Stringer); ok {
+		return stringer.String()
+	}
+	return fmt.Sprintf("<%T>", expr)
+}
+
+func expressionStrings(exprs []common.Expression) (s []string) {
+	for _, expr := range exprs {
+		s = append(s, expressionString(expr))
+	}
+	return s
+}
+
+func expressionsString(conditions []common.Expression) string {
+	return strings.Join(expressionStrings(conditions), " AND ")
+}
@@ -0,0 +1,160 @@
+package data
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Versions of the file format, stored in the database info. Files from before versioning read as 0.
+const (
+	// floatFormatVersion stores FLOAT values as their IEEE-754 bits instead of truncated to integers.
+	floatFormatVersion int64 = iota + 1
+
+	currentFormatVersion = floatFormatVersion
+)
+
+/*
+migrate brings a file written in an older format up to currentFormatVersion. It runs as a
+single atomic operation when the database is loaded, before anything reads the migrated
+values, and stores the new version once done.
+*/
+func (db *Database) migrate() error {
+	if db.formatVersion > currentFormatVersion {
+		return fmt.Errorf("Database `%s' has format version %d, newer than %d", db.name(), db.formatVersion, currentFormatVersion)
+	}
+
+	if db.formatVersion == currentFormatVersion {
+		return nil
+	}
+
+	return db.atomically(func() error {
+		if db.formatVersion < floatFormatVersion {
+			if err := db.migrateFloats(); err != nil {
+				return err
+			}
+		}
+
+		db.formatVersion = currentFormatVersion
+		return db.writeDbInfo()
+	})
+}
+
+/*
+migrateFloats rewrites the FLOAT values of every table, and those kept as column defaults and
+statistics, from the integer they were truncated to into their IEEE-754 bits. The fractional
+part is lost for good, but the values read the same as they did before.
+*/
+func (db *Database) migrateFloats() error {
+	for _, table := range db.dbTables {
+		columns := []dbColumn{}
+		for _, column := range table.dbColumns {
+			if column.dbTypeID == dbFloatTypeID {
+				columns = append(columns, column)
+			}
+		}
+
+		if len(columns) == 0 {
+			continue
+		}
+
+		err := db.rewriteRecords(table, func(record *dbRecord) {
+			for _, column := range columns {
+				if value, ok := record.dbTuple[column.name()].(dbFloat); ok {
+					record.insertColumnValue(migratedFloat(value), column)
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := db.migrateFloatDefaults(columns); err != nil {
+			return err
+		}
+
+		if err := db.migrateFloatStatistics(table, columns); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateFloatDefaults rewrites the default values of columns stored in SYS_DEFAULT_NUMERICS.
+func (db *Database) migrateFloatDefaults(columns []dbColumn) error {
+	defaults := map[dbType]bool{}
+	for _, column := range columns {
+		if column.hasConstraint(dbDefaultValueConstraint) {
+			defaults[column.dbDefaultValueConstraintID] = true
+		}
+	}
+
+	if len(defaults) == 0 {
+		return nil
+	}
+
+	sysNumerics := db.sysNumerics()
+	valueColumn, err := sysNumerics.column("VALUE")
+	if err != nil {
+		return err
+	}
+
+	return db.rewriteRecords(sysNumerics, func(record *dbRecord) {
+		if value, ok := record.dbTuple["SYS_DEFAULT_NUMERICS.VALUE"].(dbInteger); ok && defaults[record.dbTuple["SYS_DEFAULT_NUMERICS.VALUE_ID"]] {
+			// The column is an INTEGER, so the old bits read as the truncated value itself.
+			record.insertColumnValue(dbInteger(math.Float64bits(float64(value))), *valueColumn)
+		}
+	})
+}
+
+// migrateFloatStatistics rewrites the minimum and maximum values of columns in SYS_STATISTICS.
+func (db *Database) migrateFloatStatistics(table dbTable, columns []dbColumn) error {
+	if db.statisticsRecordBlock == nullBlockAddr {
+		return nil
+	}
+
+	columnIDs := map[dbType]bool{}
+	for _, column := range columns {
+		columnIDs[column.dbColumnID] = true
+	}
+
+	sysStatistics := db.sysStatistics()
+	return db.rewriteRecords(sysStatistics, func(record *dbRecord) {
+		if record.dbTuple["SYS_STATISTICS.TABLE_ID"] != table.dbTableID || !columnIDs[record.dbTuple["SYS_STATISTICS.COLUMN_ID"]] {
+			return
+		}
+
+		for _, name := range []string{"MIN_VALUE", "MAX_VALUE"} {
+			value, ok := record.dbTuple["SYS_STATISTICS."+name].(dbChar)
+			if !ok {
+				continue
+			}
+
+			b := append(dbChar{}, value...)
+			old := dbFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+			copy(b, migratedFloat(old).bytes())
+
+			column, _ := sysStatistics.column(name)
+			record.insertColumnValue(b, *column)
+		}
+	})
+}
+
+// migratedFloat returns the value of a FLOAT read from the bits of its old, truncated form.
+func migratedFloat(old dbFloat) dbFloat {
+	return dbFloat(int64(math.Float64bits(float64(old))))
+}
+
+// rewriteRecords calls fn with every stored record of table and writes its record blocks back.
+func (db *Database) rewriteRecords(table dbTable, fn func(*dbRecord)) error {
+	return db.scanRecordBlocks(context.Background(), table, func(addr int64, rb dbRecordBlock) (bool, error) {
+		for i := range rb.dbRecords {
+			if !rb.dbRecords[i].isFree() {
+				fn(&rb.dbRecords[i])
+			}
+		}
+		return true, db.writeAt(table.recordBlockBytes(rb), addr)
+	})
+}
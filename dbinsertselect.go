@@ -0,0 +1,149 @@
+package data
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/modest-sql/common"
+)
+
+/*
+InsertSelectCommand inserts the result of a select command into a table, like
+INSERT INTO name (columns) cmd. With no columns the result fills the table columns in order.
+*/
+type InsertSelectCommand struct {
+	tableName     string
+	columns       []string
+	selectCommand *common.SelectTableCommand
+}
+
+func NewInsertSelectCommand(tableName string, columns []string, cmd *common.SelectTableCommand) *InsertSelectCommand {
+	return &InsertSelectCommand{tableName: tableName, columns: columns, selectCommand: cmd}
+}
+
+func (c *InsertSelectCommand) TableName() string {
+	return c.tableName
+}
+
+func (c *InsertSelectCommand) Columns() []string {
+	return c.columns
+}
+
+func (c *InsertSelectCommand) SelectCommand() *common.SelectTableCommand {
+	return c.selectCommand
+}
+
+func (db *Database) InsertSelect(name string, columns []string, cmd *common.SelectTableCommand, options ...SelectOption) error {
+	return db.InsertSelectContext(context.Background(), name, columns, cmd, options...)
+}
+
+/*
+InsertSelectContext inserts every row selected by cmd into the table name as it is produced.
+The i-th result column goes to the i-th of columns, and its values are converted like
+those given to Insert. Either all the rows are inserted or none is.
+*/
+func (db *Database) InsertSelectContext(ctx context.Context, name string, columns []string, cmd *common.SelectTableCommand, options ...SelectOption) error {
+	query, err := newSelectQuery(cmd, options)
+	if err != nil {
+		return err
+	}
+
+	return db.atomically(func() error {
+		return db.insertSelect(ctx, name, columns, query)
+	})
+}
+
+func (db *Database) insertSelect(ctx context.Context, name string, columns []string, query selectQuery) error {
+	table, err := db.writableTable(name)
+	if err != nil {
+		return err
+	}
+
+	resetSubqueries(ctx, query.expressions()...)
+
+	plan, err := db.plan(query)
+	if err != nil {
+		return err
+	}
+
+	if len(columns) == 0 {
+		for _, column := range table.dbColumns {
+			columns = append(columns, column.name())
+		}
+	}
+
+	sources := plan.columns()
+	if len(sources) != len(columns) {
+		return fmt.Errorf("Select returns %d columns but %d columns of Table `%s' are given", len(sources), len(columns), name)
+	}
+
+	// The UNIQUE constraints are checked once every row is inserted, instead of scanning the table for each row.
+	inserted := []dbTuple{}
+	insert := func(tuple dbTuple) (bool, error) {
+		values := map[string]interface{}{}
+		for i, source := range sources {
+			values[columns[i]] = stdType(tuple[source.name])
+		}
+
+		values, err := db.resolveSequences(ctx, *table, values, true)
+		if err != nil {
+			return false, err
+		}
+
+		dbValues, err := convertValuesMap(*table, values)
+		if err != nil {
+			return false, err
+		}
+
+		row, err := db.insertRow(ctx, *table, dbValues)
+		if err != nil {
+			return false, err
+		}
+
+		inserted = append(inserted, row)
+		return true, db.rowChanged(ctx, *table, nil, row)
+	}
+
+	if !db.queryReads(query, name) {
+		if err := plan.run(ctx, db, insert); err != nil {
+			return err
+		}
+		return db.checkUniques(ctx, *table, inserted)
+	}
+
+	// Rows selected from the target table itself are all read before the first is
+	// inserted, so the select doesn't see them.
+	set := dbSet{}
+	if err := plan.run(ctx, db, func(tuple dbTuple) (bool, error) {
+		set = append(set, tuple)
+		return true, nil
+	}); err != nil {
+		return err
+	}
+
+	for _, tuple := range set {
+		if _, err := insert(tuple); err != nil {
+			return err
+		}
+	}
+	return db.checkUniques(ctx, *table, inserted)
+}
+
+// queryReads tells if query reads from the table name, directly or through views.
+func (db *Database) queryReads(query selectQuery, name string) bool {
+	for _, reference := range queryReferences(query) {
+		if reference == name {
+			return true
+		}
+
+		view, ok := db.dbViews[reference]
+		if !ok {
+			continue
+		}
+
+		if viewQuery, err := db.unmarshalQuery(view.definition); err == nil && db.queryReads(viewQuery, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package data
+
+import "fmt"
+
+/*
+dbJournal keeps the state a write operation started from: the original contents of every
+block it overwrites, the database info and the in-memory catalog. Rolling it back undoes
+all writes made since, so an operation that fails or is cancelled halfway leaves no trace.
+*/
+type dbJournal struct {
+	dbInfo              dbInfo
+	dbTableIDs          map[string]dbInteger
+	dbTables            []dbTable
+	dbViews             map[string]*dbView
+	dbMaterializedViews map[string]*dbMaterializedView
+	dbTriggers          map[string][]dbTrigger
+	dbSequences         map[string]*dbSequence
+	blocks              map[int64][]byte
+}
+
+func newDBJournal(db *Database) *dbJournal {
+	dbTableIDs := map[string]dbInteger{}
+	for name, id := range db.dbTableIDs {
+		dbTableIDs[name] = id
+	}
+
+	dbViews := map[string]*dbView{}
+	for name, view := range db.dbViews {
+		dbViews[name] = view
+	}
+
+	dbMaterializedViews := map[string]*dbMaterializedView{}
+	for name, view := range db.dbMaterializedViews {
+		dbMaterializedViews[name] = view
+	}
+
+	dbTriggers := map[string][]dbTrigger{}
+	for name, triggers := range db.dbTriggers {
+		dbTriggers[name] = triggers
+	}
+
+	dbSequences := map[string]*dbSequence{}
+	for name, sequence := range db.dbSequences {
+		dbSequences[name] = sequence
+	}
+
+	return &dbJournal{
+		dbInfo:              db.dbInfo,
+		dbTableIDs:          dbTableIDs,
+		dbTables:            append([]dbTable{}, db.dbTables...),
+		dbViews:             dbViews,
+		dbMaterializedViews: dbMaterializedViews,
+		dbTriggers:          dbTriggers,
+		dbSequences:         dbSequences,
+		blocks:              map[int64][]byte{},
+	}
+}
+
+// save keeps the contents of the block at addr before it is first overwritten.
+func (j *dbJournal) save(db Database, addr int64) error {
+	if _, ok := j.blocks[addr]; ok || addr > j.dbInfo.blocks {
+		// Blocks past the original end of file are discarded by truncation on rollback.
+		return nil
+	}
+
+	block, err := db.readAt(addr)
+	if err != nil {
+		return err
+	}
+
+	j.blocks[addr] = block
+	return nil
+}
+
+func (j *dbJournal) rollback(db *Database) error {
+	for addr, block := range j.blocks {
+		if err := db.writeAt(block, addr); err != nil {
+			return err
+		}
+	}
+
+	db.dbInfo = j.dbInfo
+	if err := db.writeDbInfo(); err != nil {
+		return err
+	}
+
+	if err := db.dbFile.Truncate(db.blockSize * db.blocks); err != nil {
+		return err
+	}
+
+	db.dbTableIDs, db.dbTables, db.dbViews = j.dbTableIDs, j.dbTables, j.dbViews
+	db.dbMaterializedViews, db.dbTriggers = j.dbMaterializedViews, j.dbTriggers
+	db.dbSequences = j.dbSequences
+	return nil
+}
+
+/*
+atomically runs fn, rolling back every change it made if it returns an error. Nested
+calls join the outermost one.
+*/
+func (db *Database) atomically(fn func() error) error {
+	if db.journal != nil {
+		return fn()
+	}
+
+	db.journal = newDBJournal(db)
+	err := fn()
+	if err == nil && len(db.pendingChanges) > 0 {
+		// The sequence of the last change is stored with the changes.
+		err = db.writeDbInfo()
+	}
+
+	journal := db.journal
+	db.journal = nil
+
+	if err != nil {
+		db.pendingChanges = nil
+		if rollbackErr := journal.rollback(db); rollbackErr != nil {
+			return fmt.Errorf("%v (rollback failed: %v)", err, rollbackErr)
+		}
+		return err
+	}
+
+	db.publishChanges()
+	return nil
+}
@@ -3,4 +3,24 @@ package data
 const (
 	maxNameLength = 64
 	maxCharLength = 256
+	// VARCHAR lengths are stored in 16 bits.
+	maxVarcharLength = 1<<16 - 1
 )
+
+const (
+	maxDistinctTuples  = 1 << 16
+	distinctPartitions = 16
+)
+
+const (
+	distinctSketchSize       = 1024
+	maxStatisticsValueLength = 32
+)
+
+const (
+	changeBufferSize  = 256
+	changeHistorySize = 1024
+)
+
+// Number of values of a sequence reserved at once.
+const sequenceCacheSize = 32
@@ -0,0 +1,364 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/modest-sql/common"
+)
+
+/*
+dbMaterializedView is a view whose result is stored in the record blocks of the table of
+the same name. Its definition is kept in SYS_MATERIALIZED_VIEWS. Views reading a single
+table without joins, DISTINCT, LIMIT, OFFSET or subqueries are maintained incrementally
+as that table changes; the rest are only updated by RefreshMaterializedView.
+*/
+type dbMaterializedView struct {
+	dbTableID   dbInteger
+	name        string
+	definition  string
+	query       selectQuery
+	incremental bool
+	// sources are the names of the query's result columns, in table column order.
+	sources []string
+}
+
+func (db *Database) CreateMaterializedView(name string, cmd *common.SelectTableCommand, options ...SelectOption) error {
+	return db.CreateMaterializedViewContext(context.Background(), name, cmd, options...)
+}
+
+/*
+CreateMaterializedViewContext creates the table name with the columns produced by cmd,
+fills it with the result of cmd and keeps cmd to refresh it later.
+*/
+func (db *Database) CreateMaterializedViewContext(ctx context.Context, name string, cmd *common.SelectTableCommand, options ...SelectOption) error {
+	query, err := newSelectQuery(cmd, options)
+	if err != nil {
+		return err
+	}
+
+	return db.atomically(func() error {
+		return db.createMaterializedView(ctx, name, query)
+	})
+}
+
+func (db *Database) createMaterializedView(ctx context.Context, name string, query selectQuery) error {
+	definition, err := marshalQuery(query)
+	if err != nil {
+		return err
+	}
+
+	resetSubqueries(ctx, query.expressions()...)
+	plan, err := db.plan(query)
+	if err != nil {
+		return err
+	}
+
+	table, err := db.allocTable(name)
+	if err != nil {
+		return err
+	}
+
+	for pos, source := range plan.columns() {
+		column, err := db.newResultColumn(table, source, pos)
+		if err != nil {
+			return err
+		}
+
+		if err := table.addColumn(column); err != nil {
+			return fmt.Errorf("Column `%s' appears more than once in materialized view `%s'", trimName(column.dbColumnName), name)
+		}
+	}
+
+	if err := db.storeTable(ctx, table); err != nil {
+		return err
+	}
+
+	if db.materializedViewsRecordBlock == nullBlockAddr {
+		if err := db.createSysTable(&db.materializedViewsRecordBlock, db.sysMaterializedViews()); err != nil {
+			return err
+		}
+	}
+
+	for chunk, part := range definitionChunks(definition) {
+		values := map[string]dbType{
+			"TABLE_ID":   table.dbTableID,
+			"CHUNK":      dbInteger(chunk),
+			"DEFINITION": part,
+		}
+
+		if err := db.insert(ctx, db.sysMaterializedViews(), values); err != nil {
+			return err
+		}
+	}
+
+	view, err := db.newMaterializedView(table.dbTableID, name, definition)
+	if err != nil {
+		return err
+	}
+	db.dbMaterializedViews[name] = view
+
+	return db.refreshMaterializedView(ctx, view)
+}
+
+/*
+newResultColumn creates a column of a materialized view for a column of its query result.
+Untyped columns can't be stored; CHAR columns of unknown size get the maximum size.
+*/
+func (db *Database) newResultColumn(table dbTable, source planColumn, pos int) (dbColumn, error) {
+	_, columnName := splitIdentifier(source.name)
+	if !source.typed {
+		return dbColumn{}, fmt.Errorf("The type of column `%s' can't be determined", columnName)
+	}
+
+	name := make(dbChar, maxNameLength)
+	copy(name, columnName)
+
+	column := dbColumn{
+		dbTable:          table,
+		dbTableID:        table.dbTableID,
+		dbColumnID:       dbInteger(db.columns + 1),
+		dbColumnPosition: dbInteger(pos),
+		dbTypeID:         source.typeID,
+		dbTypeSize:       source.size,
+		dbColumnName:     name,
+	}
+
+	if column.dbTypeID == dbCharTypeID && column.dbTypeSize == 0 {
+		column.dbTypeSize = maxCharLength
+	}
+
+	db.dbInfo.columns++
+	return column, db.writeDbInfo()
+}
+
+func (db *Database) RefreshMaterializedView(name string) error {
+	return db.RefreshMaterializedViewContext(context.Background(), name)
+}
+
+// RefreshMaterializedViewContext replaces the rows of a materialized view by the current result of its query.
+func (db *Database) RefreshMaterializedViewContext(ctx context.Context, name string) error {
+	view, ok := db.dbMaterializedViews[name]
+	if !ok {
+		return fmt.Errorf("Materialized view `%s' does not exist in Database `%s'", name, db.name())
+	}
+
+	return db.atomically(func() error {
+		return db.refreshMaterializedView(ctx, view)
+	})
+}
+
+func (db *Database) refreshMaterializedView(ctx context.Context, view *dbMaterializedView) error {
+	table, err := db.table(view.name)
+	if err != nil {
+		return err
+	}
+
+	if err := db.delete(ctx, *table, nil, nil); err != nil {
+		return err
+	}
+
+	resetSubqueries(ctx, view.query.expressions()...)
+	plan, err := db.plan(view.query)
+	if err != nil {
+		return err
+	}
+
+	return plan.run(ctx, db, func(tuple dbTuple) (bool, error) {
+		values, err := view.values(*table, tuple)
+		if err != nil {
+			return false, err
+		}
+
+		return true, db.insert(ctx, *table, values)
+	})
+}
+
+// values converts a row of the view's query result to the values of a row of its table.
+func (v *dbMaterializedView) values(table dbTable, tuple dbTuple) (map[string]dbType, error) {
+	values := map[string]dbType{}
+	for i, column := range table.dbColumns {
+		value := tuple[v.sources[i]]
+		if integer, ok := value.(dbInteger); ok && column.dbTypeID == dbFloatTypeID {
+			value = dbFloat(integer)
+		}
+
+		if value != nil && value.dbTypeID() != column.dbTypeID {
+			return nil, fmt.Errorf("Value %v doesn't match the type of column `%s'", stdType(value), column.name())
+		}
+
+		if char, ok := value.(dbChar); ok && len(char) > int(column.dbTypeSize) {
+			value = char[:column.dbTypeSize]
+		}
+
+		values[column.name()] = value
+	}
+	return values, nil
+}
+
+func (db *Database) newMaterializedView(tableID dbInteger, name string, definition string) (*dbMaterializedView, error) {
+	query, err := db.unmarshalQuery(definition)
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := db.plan(query)
+	if err != nil {
+		return nil, fmt.Errorf("Materialized view `%s' is invalid: %v", name, err)
+	}
+
+	view := &dbMaterializedView{dbTableID: tableID, name: name, definition: definition, query: query}
+	for _, column := range plan.columns() {
+		view.sources = append(view.sources, column.name)
+	}
+
+	_, isView := db.dbViews[query.table]
+	view.incremental = !isView && len(query.joins) == 0 && !query.distinct && query.limit == noLimit &&
+		query.offset == 0 && !containsSubquery(query.expressions()...)
+
+	return view, nil
+}
+
+/*
+maintainMaterializedViews applies a change of a row of table to the incrementally
+maintained views reading from it: the view row of the old image is deleted and that of
+the new image inserted, for the images satisfying the view's condition.
+*/
+func (db *Database) maintainMaterializedViews(ctx context.Context, table dbTable, old dbTuple, new dbTuple) error {
+	for _, view := range db.dbMaterializedViews {
+		if !view.incremental || view.query.table != table.name() {
+			continue
+		}
+
+		viewTable, err := db.table(view.name)
+		if err != nil {
+			return err
+		}
+
+		images := []dbTuple{old, new}
+		for i, image := range images {
+			if image == nil {
+				continue
+			}
+
+			image = image.withRelation(table.name(), view.query.relation())
+			if ok, err := evaluatePredicates(conjuncts(view.query.condition), image); err != nil {
+				return err
+			} else if !ok {
+				continue
+			}
+
+			projected, err := projectTuple(image, view.query.columns)
+			if err != nil {
+				return err
+			}
+
+			values, err := view.values(*viewTable, projected)
+			if err != nil {
+				return err
+			}
+
+			if i == 0 {
+				err = db.deleteOne(ctx, *viewTable, values)
+			} else {
+				err = db.insert(ctx, *viewTable, values)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+deleteOne deletes a single row of the table holding values. Rows of a materialized view
+may repeat, and each deleted source row accounts for exactly one of them.
+*/
+func (db *Database) deleteOne(ctx context.Context, table dbTable, values map[string]dbType) error {
+	key := tupleKey(dbTuple(values))
+
+	deleted := false
+	err := db.scanRecordBlocks(ctx, table, func(addr int64, rb dbRecordBlock) (bool, error) {
+		for i := range rb.dbRecords {
+			if rb.dbRecords[i].isFree() || tupleKey(rb.dbRecords[i].dbTuple) != key {
+				continue
+			}
+
+			rb.dbRecords[i].freeFlag = freeFlag
+			if err := db.writeAt(table.recordBlockBytes(rb), addr); err != nil {
+				return false, err
+			}
+
+			deleted = true
+			return false, db.rowChanged(ctx, table, rb.dbRecords[i].dbTuple, nil)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if !deleted {
+		return fmt.Errorf("Materialized view `%s' is missing a row; refresh it", table.name())
+	}
+	return nil
+}
+
+func (db Database) sysMaterializedViews() dbTable {
+	return newMaterializedViewsSysTable(dbInteger(db.materializedViewsRecordBlock))
+}
+
+// loadMaterializedViews reads the definitions stored in SYS_MATERIALIZED_VIEWS.
+func (db *Database) loadMaterializedViews() error {
+	if db.materializedViewsRecordBlock == nullBlockAddr {
+		return nil
+	}
+
+	set, err := db.tableSet(db.sysMaterializedViews())
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(set, func(i, j int) bool {
+		return set[i]["SYS_MATERIALIZED_VIEWS.CHUNK"].(dbInteger) < set[j]["SYS_MATERIALIZED_VIEWS.CHUNK"].(dbInteger)
+	})
+
+	definitions := map[dbInteger]string{}
+	for _, row := range set {
+		tableID := row["SYS_MATERIALIZED_VIEWS.TABLE_ID"].(dbInteger)
+		definitions[tableID] += trimName(row["SYS_MATERIALIZED_VIEWS.DEFINITION"].(dbChar))
+	}
+
+	for _, table := range db.dbTables {
+		definition, ok := definitions[table.dbTableID]
+		if !ok {
+			continue
+		}
+
+		view, err := db.newMaterializedView(table.dbTableID, table.name(), definition)
+		if err != nil {
+			return err
+		}
+		db.dbMaterializedViews[table.name()] = view
+	}
+
+	return nil
+}
+
+// dropMaterializedView forgets the definition of the materialized view stored in table.
+func (db *Database) dropMaterializedView(ctx context.Context, table dbTable) error {
+	if _, ok := db.dbMaterializedViews[table.name()]; !ok {
+		return nil
+	}
+
+	condition := dropCondition("SYS_MATERIALIZED_VIEWS", "TABLE_ID", int64(table.dbTableID))
+	if err := db.delete(ctx, db.sysMaterializedViews(), condition, nil); err != nil {
+		return err
+	}
+
+	delete(db.dbMaterializedViews, table.name())
+	return nil
+}
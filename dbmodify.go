@@ -0,0 +1,63 @@
+package data
+
+// ModifyOption modifies what Update and Delete report about the rows they change.
+type ModifyOption func(*ModifyResult)
+
+// Returning makes Update and Delete return the rows they change, like RETURNING *.
+func Returning() ModifyOption {
+	return func(r *ModifyResult) {
+		r.returning = true
+	}
+}
+
+/*
+ModifyResult reports the rows changed by Update or Delete. Rows is only filled when the
+Returning option is given, in the order the rows were changed.
+*/
+type ModifyResult struct {
+	RowsAffected int64
+	Rows         []ModifiedRow
+	returning    bool
+}
+
+/*
+ModifiedRow holds the values of a changed row by column name, before and after the change.
+After is nil for deleted rows.
+*/
+type ModifiedRow struct {
+	Before map[string]interface{}
+	After  map[string]interface{}
+}
+
+func newModifyResult(options []ModifyOption) *ModifyResult {
+	result := &ModifyResult{Rows: []ModifiedRow{}}
+	for _, option := range options {
+		option(result)
+	}
+	return result
+}
+
+// add counts a changed row. Internal changes, like those of the catalog, have no result.
+func (r *ModifyResult) add(old dbTuple, new dbTuple) {
+	if r == nil {
+		return
+	}
+
+	r.RowsAffected++
+	if r.returning {
+		r.Rows = append(r.Rows, ModifiedRow{Before: rowImage(old), After: rowImage(new)})
+	}
+}
+
+func rowImage(tuple dbTuple) map[string]interface{} {
+	if tuple == nil {
+		return nil
+	}
+
+	image := map[string]interface{}{}
+	for key, value := range tuple {
+		_, columnName := splitIdentifier(key)
+		image[columnName] = stdType(value)
+	}
+	return image
+}
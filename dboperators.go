@@ -1,6 +1,8 @@
 package data
 
 import (
+	"fmt"
+
 	"github.com/modest-sql/common"
 )
 
@@ -42,6 +44,32 @@ func projection(r dbSet, names []string) (result dbSet) {
 	return r
 }
 
+func projectTuple(t dbTuple, columns []projectedColumn) (result dbTuple, err error) {
+	result = dbTuple{}
+
+	var symbols map[string]interface{}
+	for _, column := range columns {
+		if column.expression == nil {
+			for name, value := range t {
+				if containsName(name, []string{column.name}) {
+					result[name] = value
+				}
+			}
+			continue
+		}
+
+		if symbols == nil {
+			symbols = t.stdMap()
+		}
+
+		if result[column.name], err = castStdType(column.expression.Evaluate(symbols)); err != nil {
+			return nil, fmt.Errorf("Column `%s': %v", column.name, err)
+		}
+	}
+
+	return result, nil
+}
+
 func joinByAttribute(r dbSet, s dbSet, theta binaryOperator, a string, b string) (result dbSet) {
 	for i := range r {
 		for j := range s {
@@ -0,0 +1,226 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/modest-sql/common"
+)
+
+// scannedBlock holds the tuples a scan worker kept from one record block.
+type scannedBlock struct {
+	tuples []dbTuple
+	err    error
+}
+
+/*
+SetParallelism sets the number of workers used to read and filter the record blocks of a
+table scan. A value of 1, the default, scans every table sequentially.
+*/
+func (db *Database) SetParallelism(workers int) error {
+	if workers < 1 {
+		return errors.New("Parallelism must be greater than 0")
+	}
+
+	db.parallelism = workers
+	return nil
+}
+
+// Parallelism returns the number of workers used by table scans.
+func (db Database) Parallelism() int {
+	if db.parallelism < 1 {
+		return 1
+	}
+	return db.parallelism
+}
+
+/*
+blockDirectory returns the addresses of the table's record blocks in chain order. Only the
+next block pointer of each block is read, so the directory is cheap to build and lets the
+blocks themselves be read in any order. The directory isn't stored: every parallel scan
+builds it again by walking the whole chain serially before any worker starts.
+*/
+func (db Database) blockDirectory(ctx context.Context, table dbTable) (directory []int64, err error) {
+	for addr := int64(table.firstRecordBlockAddr); addr != nullBlockAddr; {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		directory = append(directory, addr)
+		if addr, err = db.nextBlockAddr(addr); err != nil {
+			return nil, err
+		}
+	}
+
+	return directory, nil
+}
+
+/*
+scanTuples calls fn with every tuple of the table that satisfies filters, in block order,
+and returns the number of record blocks that were read. With a parallelism above 1 the
+blocks are read, decoded and filtered by a pool of workers while fn keeps running on the
+calling goroutine. Filters holding subqueries aren't safe to share between workers, so
+they are evaluated by the caller instead.
+*/
+func (db Database) scanTuples(ctx context.Context, table dbTable, filters []common.Expression, fn func(dbTuple) (bool, error)) (int64, error) {
+	workers := db.Parallelism()
+
+	if workers == 1 {
+		blocks := int64(0)
+		err := db.scanRecordBlocks(ctx, table, func(addr int64, rb dbRecordBlock) (bool, error) {
+			blocks++
+
+			tuples, err := filterRecordBlock(rb, filters)
+			if err != nil {
+				return false, err
+			}
+
+			for _, tuple := range tuples {
+				if more, err := fn(tuple); err != nil || !more {
+					return false, err
+				}
+			}
+
+			return true, nil
+		})
+		return blocks, err
+	}
+
+	directory, err := db.blockDirectory(ctx, table)
+	if err != nil {
+		return 0, err
+	}
+
+	workerFilters, callerFilters := filters, []common.Expression(nil)
+	if containsSubquery(filters...) {
+		workerFilters, callerFilters = nil, filters
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	results := make([]chan scannedBlock, len(directory))
+	for i := range results {
+		results[i] = make(chan scannedBlock, 1)
+	}
+
+	// window bounds how far the workers may run ahead of fn.
+	window := make(chan struct{}, 2*workers)
+	jobs := make(chan int)
+
+	go func() {
+		defer close(jobs)
+		for i := range directory {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] <- db.scanBlock(table, directory[i], workerFilters)
+			}
+		}()
+	}
+
+	blocks := int64(0)
+	for i := range results {
+		var result scannedBlock
+		select {
+		case result = <-results[i]:
+		case <-ctx.Done():
+			return blocks, ctx.Err()
+		}
+		<-window
+		blocks++
+
+		if result.err != nil {
+			return blocks, result.err
+		}
+
+		for _, tuple := range result.tuples {
+			if ok, err := evaluatePredicates(callerFilters, tuple); err != nil {
+				return blocks, err
+			} else if !ok {
+				continue
+			}
+
+			if more, err := fn(tuple); err != nil || !more {
+				return blocks, err
+			}
+		}
+	}
+
+	return blocks, nil
+}
+
+/*
+scanBlock reads the record block at addr and keeps the tuples that satisfy filters. It runs
+on a worker goroutine, where a panic would bring down the whole process, so a panic is
+returned as the error of the block instead.
+*/
+func (db Database) scanBlock(table dbTable, addr int64, filters []common.Expression) (result scannedBlock) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = scannedBlock{err: fmt.Errorf("Record block %d of Table `%s' can't be scanned: %v", addr, table.name(), r)}
+		}
+	}()
+
+	block, err := db.readAt(addr)
+	if err != nil {
+		return scannedBlock{err: err}
+	}
+
+	tuples, err := filterRecordBlock(table.loadRecordBlockBytes(block), filters)
+	return scannedBlock{tuples: tuples, err: err}
+}
+
+// filterRecordBlock returns the tuples of the used records of rb that satisfy filters.
+func filterRecordBlock(rb dbRecordBlock, filters []common.Expression) (tuples []dbTuple, err error) {
+	for i := range rb.dbRecords {
+		if rb.dbRecords[i].isFree() {
+			continue
+		}
+
+		tuple := rb.dbRecords[i].dbTuple
+		if ok, err := evaluatePredicates(filters, tuple); err != nil {
+			return nil, err
+		} else if ok {
+			tuples = append(tuples, tuple)
+		}
+	}
+
+	return tuples, nil
+}
+
+// containsSubquery reports whether any of exprs holds a subquery.
+func containsSubquery(exprs ...common.Expression) bool {
+	for _, expr := range exprs {
+		if _, ok := expr.(*SubqueryExpression); ok {
+			return true
+		}
+
+		if composite, ok := expr.(compositeExpression); ok && containsSubquery(composite.operands()...) {
+			return true
+		}
+	}
+	return false
+}
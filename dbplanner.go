@@ -0,0 +1,797 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/modest-sql/common"
+)
+
+const (
+	equalitySelectivity = 10
+	defaultSelectivity  = 3
+)
+
+/*
+tupleEmitter receives the tuples produced by a plan node. Returning false stops the
+producer, which lets LIMIT end scans early.
+*/
+type tupleEmitter func(dbTuple) (bool, error)
+
+// planColumn describes a column produced by a plan node.
+type planColumn struct {
+	name     string
+	table    string
+	typeID   dbTypeID
+	size     dbInteger
+	nullable bool
+	typed    bool
+}
+
+type planNode interface {
+	run(ctx context.Context, db *Database, emit tupleEmitter) error
+	columns() []planColumn
+	estimate() int64
+	explain() (operator string, detail string)
+	inputs() []*planNode
+}
+
+type scanNode struct {
+	table      dbTable
+	filters    []common.Expression
+	rows       int64
+	workers    int
+	blocksRead int64
+	// view produces the rows of a referenced view instead of the table's record blocks.
+	view           planNode
+	viewSubqueries []common.Expression
+}
+
+func (n *scanNode) run(ctx context.Context, db *Database, emit tupleEmitter) error {
+	if n.view != nil {
+		return n.viewScan(ctx, db, emit)
+	}
+
+	blocks, err := db.scanTuples(ctx, n.table, n.filters, emit)
+	n.blocksRead += blocks
+	return err
+}
+
+func (n *scanNode) explain() (string, string) {
+	operator := "Seq Scan"
+	if n.view != nil {
+		operator = "View Scan"
+	} else if n.workers > 1 {
+		operator = fmt.Sprintf("Parallel Seq Scan (%d workers)", n.workers)
+	}
+
+	relation := tableReference(n.table.sourceTableName(), n.table.name())
+	if len(n.filters) == 0 {
+		return operator, relation
+	}
+	return operator, fmt.Sprintf("%s filter: %s", relation, expressionsString(n.filters))
+}
+
+func (n *scanNode) inputs() []*planNode {
+	if n.view != nil {
+		return []*planNode{&n.view}
+	}
+	return nil
+}
+
+func (n *scanNode) columns() (columns []planColumn) {
+	if n.view != nil {
+		for i, column := range n.view.columns() {
+			column.name, column.table = n.table.dbColumns[i].name(), n.table.name()
+			columns = append(columns, column)
+		}
+		return columns
+	}
+
+	for _, column := range n.table.dbColumns {
+		columns = append(columns, planColumn{
+			name:     column.name(),
+			table:    n.table.name(),
+			typeID:   column.dbTypeID,
+			size:     column.dbTypeSize,
+			nullable: !column.hasConstraint(dbNotNullConstraint),
+			typed:    true,
+		})
+	}
+	return columns
+}
+
+func (n *scanNode) estimate() int64 {
+	rows := n.rows
+	for _, filter := range n.filters {
+		rows /= predicateSelectivity(filter)
+	}
+
+	if rows < 1 {
+		return 1
+	}
+	return rows
+}
+
+/*
+joinNode streams the tuples of left and matches each one against right, which is read
+once into memory. When the join has equality predicates between both sides, right is
+hashed on them so every left tuple only visits its matching tuples.
+*/
+type joinNode struct {
+	left      planNode
+	right     planNode
+	leftKeys  []common.Expression
+	rightKeys []common.Expression
+	criteria  []common.Expression
+}
+
+func (n *joinNode) run(ctx context.Context, db *Database, emit tupleEmitter) error {
+	buckets := map[string]dbSet{}
+	err := n.right.run(ctx, db, func(tuple dbTuple) (bool, error) {
+		key, ok, err := joinKey(n.rightKeys, tuple)
+		if err == nil && ok {
+			buckets[key] = append(buckets[key], tuple)
+		}
+		return err == nil, err
+	})
+	if err != nil {
+		return err
+	}
+
+	return n.left.run(ctx, db, func(tuple dbTuple) (bool, error) {
+		key, ok, err := joinKey(n.leftKeys, tuple)
+		if err != nil || !ok {
+			return err == nil, err
+		}
+
+		for _, match := range buckets[key] {
+			merged := mergeTuples(tuple, match)
+			if ok, err := evaluatePredicates(n.criteria, merged); err != nil {
+				return false, err
+			} else if !ok {
+				continue
+			}
+
+			if more, err := emit(merged); err != nil || !more {
+				return false, err
+			}
+		}
+
+		return true, nil
+	})
+}
+
+func (n *joinNode) explain() (string, string) {
+	conditions := []string{}
+	for i := range n.leftKeys {
+		conditions = append(conditions, fmt.Sprintf("%s = %s", expressionString(n.leftKeys[i]), expressionString(n.rightKeys[i])))
+	}
+
+	if len(n.criteria) > 0 {
+		conditions = append(conditions, expressionsString(n.criteria))
+	}
+
+	operator := "Hash Join"
+	if len(n.leftKeys) == 0 {
+		operator = "Nested Loop Join"
+	}
+
+	return operator, strings.Join(conditions, " AND ")
+}
+
+func (n *joinNode) inputs() []*planNode {
+	return []*planNode{&n.left, &n.right}
+}
+
+func (n *joinNode) columns() []planColumn {
+	return append(n.left.columns(), n.right.columns()...)
+}
+
+func (n *joinNode) estimate() int64 {
+	rows := n.left.estimate() * n.right.estimate()
+	for range n.leftKeys {
+		rows /= maxInt64(n.right.estimate(), 1)
+	}
+
+	for _, criterion := range n.criteria {
+		rows /= predicateSelectivity(criterion)
+	}
+
+	if rows < 1 {
+		return 1
+	}
+	return rows
+}
+
+type filterNode struct {
+	input      planNode
+	predicates []common.Expression
+}
+
+func (n *filterNode) run(ctx context.Context, db *Database, emit tupleEmitter) error {
+	return n.input.run(ctx, db, func(tuple dbTuple) (bool, error) {
+		if ok, err := evaluatePredicates(n.predicates, tuple); err != nil || !ok {
+			return err == nil, err
+		}
+
+		return emit(tuple)
+	})
+}
+
+func (n *filterNode) explain() (string, string) {
+	return "Filter", expressionsString(n.predicates)
+}
+
+func (n *filterNode) inputs() []*planNode {
+	return []*planNode{&n.input}
+}
+
+func (n *filterNode) columns() []planColumn {
+	return n.input.columns()
+}
+
+func (n *filterNode) estimate() int64 {
+	rows := n.input.estimate()
+	for _, predicate := range n.predicates {
+		rows /= predicateSelectivity(predicate)
+	}
+	return maxInt64(rows, 1)
+}
+
+/*
+projectNode computes the targets of a query from the tuples of input. sources lists the
+columns of input in the order the query names its tables, which a join reordered by the
+planner doesn't keep, so that `*' always expands the same way.
+*/
+type projectNode struct {
+	input   planNode
+	targets []projectedColumn
+	sources []planColumn
+}
+
+func (n *projectNode) run(ctx context.Context, db *Database, emit tupleEmitter) error {
+	return n.input.run(ctx, db, func(tuple dbTuple) (bool, error) {
+		projected, err := projectTuple(tuple, n.targets)
+		if err != nil {
+			return false, err
+		}
+
+		return emit(projected)
+	})
+}
+
+func (n *projectNode) explain() (string, string) {
+	targets := []string{}
+	for _, target := range n.targets {
+		if target.expression != nil {
+			targets = append(targets, fmt.Sprintf("%s AS %s", expressionString(target.expression), target.name))
+		} else {
+			targets = append(targets, target.name)
+		}
+	}
+	return "Project", strings.Join(targets, ", ")
+}
+
+func (n *projectNode) inputs() []*planNode {
+	return []*planNode{&n.input}
+}
+
+func (n *projectNode) columns() (columns []planColumn) {
+	input := append([]planColumn{}, n.sources...)
+	if n.sources == nil {
+		input = n.input.columns()
+	}
+	for _, target := range n.targets {
+		if target.expression != nil {
+			columns = append(columns, expressionColumn(target.name, target.expression, input))
+			continue
+		}
+
+		for _, column := range input {
+			if containsName(column.name, []string{target.name}) {
+				columns = append(columns, column)
+			}
+		}
+	}
+	return columns
+}
+
+func (n *projectNode) estimate() int64 {
+	return n.input.estimate()
+}
+
+type distinctNode struct {
+	input planNode
+}
+
+func (n *distinctNode) run(ctx context.Context, db *Database, emit tupleEmitter) error {
+	filter := newDistinctFilter(maxDistinctTuples)
+	defer filter.close()
+
+	stopped := false
+	err := n.input.run(ctx, db, func(tuple dbTuple) (bool, error) {
+		unique, err := filter.add(tuple)
+		if err != nil || !unique {
+			return err == nil, err
+		}
+
+		more, err := emit(tuple)
+		stopped = !more
+		return more, err
+	})
+	if err != nil || stopped {
+		return err
+	}
+
+	return filter.flush(emit)
+}
+
+func (n *distinctNode) explain() (string, string) {
+	return "Distinct", ""
+}
+
+func (n *distinctNode) inputs() []*planNode {
+	return []*planNode{&n.input}
+}
+
+func (n *distinctNode) columns() []planColumn {
+	return n.input.columns()
+}
+
+func (n *distinctNode) estimate() int64 {
+	return n.input.estimate()
+}
+
+type limitNode struct {
+	input  planNode
+	limit  int64
+	offset int64
+}
+
+func (n *limitNode) run(ctx context.Context, db *Database, emit tupleEmitter) error {
+	if n.limit == 0 {
+		return nil
+	}
+
+	skipped, emitted := int64(0), int64(0)
+	return n.input.run(ctx, db, func(tuple dbTuple) (bool, error) {
+		if skipped < n.offset {
+			skipped++
+			return true, nil
+		}
+
+		if more, err := emit(tuple); err != nil || !more {
+			return false, err
+		}
+
+		emitted++
+		return n.limit < 0 || emitted < n.limit, nil
+	})
+}
+
+func (n *limitNode) explain() (string, string) {
+	if n.limit < 0 {
+		return "Limit", fmt.Sprintf("offset %d", n.offset)
+	}
+	return "Limit", fmt.Sprintf("%d offset %d", n.limit, n.offset)
+}
+
+func (n *limitNode) inputs() []*planNode {
+	return []*planNode{&n.input}
+}
+
+func (n *limitNode) columns() []planColumn {
+	return n.input.columns()
+}
+
+func (n *limitNode) estimate() int64 {
+	rows := n.input.estimate() - n.offset
+	if n.limit >= 0 && rows > n.limit {
+		rows = n.limit
+	}
+	return maxInt64(rows, 0)
+}
+
+/*
+plan builds the operator tree for query. The WHERE clause and join criteria are split into
+their conjuncts; those referencing a single table are evaluated by that table's scan and
+those relating tables are evaluated by the join that brings them together. Joins are
+ordered starting from the smallest estimated input.
+
+Predicates the planner can't inspect, like the expressions of package common, keep the
+join order as written and are evaluated where the original query placed them.
+*/
+func (db *Database) plan(query selectQuery) (planNode, error) {
+	tables, err := db.queryTables(query)
+	if err != nil {
+		return nil, err
+	}
+
+	scans := []*scanNode{}
+	for _, table := range tables {
+		scans = append(scans, &scanNode{table: table.dbTable, view: table.view, viewSubqueries: table.subqueries, workers: db.Parallelism()})
+	}
+
+	predicates := []*plannedPredicate{}
+	reorder := true
+	for i, clause := range append([]joinClause{{criteria: query.condition}}, query.joins...) {
+		for _, expr := range conjuncts(clause.criteria) {
+			predicate := newPlannedPredicate(expr, scans)
+			if predicate.opaque {
+				reorder = false
+				// Without knowing its columns, a WHERE conjunct is evaluated once all tables
+				// are joined and a join criterion once its own join target is.
+				last := i
+				if i == 0 {
+					last = len(scans) - 1
+				}
+
+				predicate.scans = map[int]bool{}
+				for j := 0; j <= last; j++ {
+					predicate.scans[j] = true
+				}
+			}
+			predicates = append(predicates, predicate)
+		}
+	}
+
+	var topPredicates []common.Expression
+	for _, predicate := range predicates {
+		if len(predicate.scans) == 1 && (!predicate.opaque || len(scans) == 1) {
+			for i := range predicate.scans {
+				scans[i].filters = append(scans[i].filters, predicate.expr)
+			}
+			predicate.placed = true
+		} else if len(predicate.scans) == 0 {
+			topPredicates = append(topPredicates, predicate.expr)
+			predicate.placed = true
+		}
+	}
+
+	order := []int{}
+	for i := range scans {
+		order = append(order, i)
+	}
+
+	if reorder && len(scans) > 1 {
+		for _, scan := range scans {
+			if scan.view != nil {
+				scan.rows = scan.view.estimate()
+				continue
+			}
+
+			rows, err := db.estimateRows(scan.table)
+			if err != nil {
+				return nil, err
+			}
+			scan.rows = rows
+		}
+		order = joinOrder(scans, predicates)
+	}
+
+	var node planNode = scans[order[0]]
+	covered := map[int]bool{order[0]: true}
+	for _, next := range order[1:] {
+		join := &joinNode{left: node, right: scans[next]}
+		covered[next] = true
+
+		for _, predicate := range predicates {
+			if predicate.placed || !predicate.coveredBy(covered) {
+				continue
+			}
+
+			if left, right, ok := predicate.equiJoinKeys(scans, covered, next); ok {
+				join.leftKeys = append(join.leftKeys, left)
+				join.rightKeys = append(join.rightKeys, right)
+			} else {
+				join.criteria = append(join.criteria, predicate.expr)
+			}
+			predicate.placed = true
+		}
+
+		node = join
+	}
+
+	for _, predicate := range predicates {
+		if !predicate.placed {
+			topPredicates = append(topPredicates, predicate.expr)
+		}
+	}
+
+	if len(topPredicates) > 0 {
+		node = &filterNode{input: node, predicates: topPredicates}
+	}
+
+	sources := []planColumn{}
+	for _, scan := range scans {
+		sources = append(sources, scan.columns()...)
+	}
+
+	node = &projectNode{input: node, targets: query.columns, sources: sources}
+
+	if query.distinct {
+		node = &distinctNode{input: node}
+	}
+
+	if query.limit >= 0 || query.offset > 0 {
+		node = &limitNode{input: node, limit: query.limit, offset: query.offset}
+	}
+
+	return node, nil
+}
+
+type plannedPredicate struct {
+	expr    common.Expression
+	columns []string
+	scans   map[int]bool
+	opaque  bool
+	placed  bool
+}
+
+func newPlannedPredicate(expr common.Expression, scans []*scanNode) *plannedPredicate {
+	columns, ok := expressionColumns(expr)
+	predicate := &plannedPredicate{expr: expr, columns: columns, scans: map[int]bool{}, opaque: !ok}
+
+	for _, column := range columns {
+		owners := columnOwners(column, scans)
+		if len(owners) != 1 {
+			// Unknown and ambiguous columns are left for evaluation to report.
+			predicate.opaque = true
+			continue
+		}
+		predicate.scans[owners[0]] = true
+	}
+
+	return predicate
+}
+
+func (p *plannedPredicate) coveredBy(covered map[int]bool) bool {
+	for i := range p.scans {
+		if !covered[i] {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+equiJoinKeys reports whether the predicate is an equality between a column of the scans
+already joined and a column of scan next, returning the column of each side.
+*/
+func (p *plannedPredicate) equiJoinKeys(scans []*scanNode, covered map[int]bool, next int) (common.Expression, common.Expression, bool) {
+	comparison, ok := p.expr.(*ComparisonExpression)
+	if !ok || comparison.operator != Equal || p.opaque {
+		return nil, nil, false
+	}
+
+	left, lok := comparison.left.(*ColumnExpression)
+	right, rok := comparison.right.(*ColumnExpression)
+	if !lok || !rok {
+		return nil, nil, false
+	}
+
+	leftOwner, rightOwner := columnOwners(left.name, scans)[0], columnOwners(right.name, scans)[0]
+	if rightOwner != next {
+		left, right = right, left
+		leftOwner, rightOwner = rightOwner, leftOwner
+	}
+
+	if rightOwner != next || leftOwner == next || !covered[leftOwner] {
+		return nil, nil, false
+	}
+
+	return left, right, true
+}
+
+func columnOwners(column string, scans []*scanNode) (owners []int) {
+	for i, scan := range scans {
+		if len(resolveColumn(column, columnNames(scan.columns()))) > 0 {
+			owners = append(owners, i)
+		}
+	}
+	return owners
+}
+
+// resolveColumn returns the names in columns that the qualified or unqualified column refers to.
+func resolveColumn(column string, columns []string) (matches []string) {
+	tableName, columnName := splitIdentifier(column)
+	for _, name := range columns {
+		if name == column {
+			return []string{name}
+		}
+
+		if _, c := splitIdentifier(name); tableName == "" && c == columnName {
+			matches = append(matches, name)
+		}
+	}
+	return matches
+}
+
+/*
+joinOrder starts from the scan with the smallest estimated result and repeatedly joins the
+smallest scan connected to the ones already joined by some predicate, falling back to the
+smallest remaining scan when none is connected.
+*/
+func joinOrder(scans []*scanNode, predicates []*plannedPredicate) []int {
+	remaining := []int{}
+	for i := range scans {
+		remaining = append(remaining, i)
+	}
+
+	sort.SliceStable(remaining, func(a, b int) bool {
+		return scans[remaining[a]].estimate() < scans[remaining[b]].estimate()
+	})
+
+	order := []int{remaining[0]}
+	covered := map[int]bool{remaining[0]: true}
+	remaining = remaining[1:]
+
+	for len(remaining) > 0 {
+		pick := 0
+		for i, candidate := range remaining {
+			if connected(candidate, covered, predicates) {
+				pick = i
+				break
+			}
+		}
+
+		order = append(order, remaining[pick])
+		covered[remaining[pick]] = true
+		remaining = append(remaining[:pick], remaining[pick+1:]...)
+	}
+
+	return order
+}
+
+func connected(candidate int, covered map[int]bool, predicates []*plannedPredicate) bool {
+	for _, predicate := range predicates {
+		if !predicate.scans[candidate] || len(predicate.scans) < 2 {
+			continue
+		}
+
+		for i := range predicate.scans {
+			if covered[i] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func predicateSelectivity(predicate common.Expression) int64 {
+	if comparison, ok := predicate.(*ComparisonExpression); ok && comparison.operator == Equal {
+		return equalitySelectivity
+	}
+	return defaultSelectivity
+}
+
+func evaluatePredicates(predicates []common.Expression, tuple dbTuple) (bool, error) {
+	if len(predicates) == 0 {
+		return true, nil
+	}
+
+	symbols := tuple.stdMap()
+	for _, predicate := range predicates {
+		if ok, err := evaluatePredicate(predicate, symbols); err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+/*
+joinKey evaluates the join columns of tuple into a hash key. The second result is false
+when some column is NULL, since NULL never equals anything.
+*/
+func joinKey(keys []common.Expression, tuple dbTuple) (string, bool, error) {
+	if len(keys) == 0 {
+		return "", true, nil
+	}
+
+	symbols := tuple.stdMap()
+	key := ""
+	for _, expr := range keys {
+		switch v := expr.Evaluate(symbols).(type) {
+		case nil:
+			return "", false, nil
+		case error:
+			return "", false, v
+		case int64:
+			key += "n" + strconv.FormatInt(v, 10)
+		case float64:
+			if v == float64(int64(v)) {
+				key += "n" + strconv.FormatInt(int64(v), 10)
+			} else {
+				key += "f" + strconv.FormatFloat(v, 'g', -1, 64)
+			}
+		case string:
+			key += "s" + strconv.Quote(v)
+		default:
+			key += fmt.Sprintf("%T:%v", v, v)
+		}
+		key += "\x00"
+	}
+
+	return key, true, nil
+}
+
+func maxInt64(a int64, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func columnNames(columns []planColumn) (names []string) {
+	for _, column := range columns {
+		names = append(names, column.name)
+	}
+	return names
+}
+
+/*
+expressionColumn describes the computed column name holding expr, inferring its type from
+input when possible. Its size is 0 when the expression produces CHAR values of any length.
+*/
+func expressionColumn(name string, expr common.Expression, input []planColumn) planColumn {
+	column := planColumn{name: name, nullable: true}
+
+	switch e := expr.(type) {
+	case *ColumnExpression:
+		if matches := resolveColumn(e.name, columnNames(input)); len(matches) == 1 {
+			for _, c := range input {
+				if c.name == matches[0] {
+					column.table, column.typeID, column.size, column.nullable, column.typed = c.table, c.typeID, c.size, c.nullable, c.typed
+				}
+			}
+		}
+	case *LiteralExpression:
+		if value, err := castStdType(e.value); err == nil && value != nil {
+			column.typeID, column.nullable, column.typed = value.dbTypeID(), false, true
+			if value.dbTypeID() != dbCharTypeID {
+				column.size = dbInteger(value.dbTypeSize())
+			}
+		}
+	case *ArithmeticExpression:
+		left := expressionColumn(name, e.left, input)
+		right := expressionColumn(name, e.right, input)
+		if left.typed && right.typed {
+			column.typeID, column.size, column.typed = dbFloatTypeID, dbFloatSize, true
+			if left.typeID == dbIntegerTypeID && right.typeID == dbIntegerTypeID {
+				column.typeID, column.size = dbIntegerTypeID, dbIntegerSize
+			}
+		}
+	case *ConcatExpression:
+		column.typeID, column.typed = dbCharTypeID, true
+	case *FunctionExpression:
+		switch e.name {
+		case "UPPER", "LOWER", "TRIM", "CONCAT":
+			column.typeID, column.typed = dbCharTypeID, true
+		case "LENGTH":
+			column.typeID, column.size, column.typed = dbIntegerTypeID, dbIntegerSize, true
+		case "ABS", "COALESCE":
+			for _, argument := range e.arguments {
+				if argumentColumn := expressionColumn(name, argument, input); argumentColumn.typed {
+					argumentColumn.nullable = true
+					return argumentColumn
+				}
+			}
+		}
+	case *ComparisonExpression, *AndExpression, *OrExpression, *NotExpression, *IsNullExpression:
+		column.typeID, column.size, column.typed = dbBooleanTypeID, dbBooleanSize, true
+	case *SubqueryExpression:
+		if e.kind != scalarSubquery {
+			column.typeID, column.size, column.typed = dbBooleanTypeID, dbBooleanSize, true
+		} else if plan, err := e.db.plan(e.query); err == nil && len(plan.columns()) == 1 {
+			column = plan.columns()[0]
+			column.name, column.table, column.nullable = name, "", true
+		}
+	}
+
+	return column
+}
@@ -0,0 +1,288 @@
+package data
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/modest-sql/common"
+)
+
+const (
+	noLimit int64 = -1
+)
+
+type joinClause struct {
+	table    string
+	alias    string
+	criteria common.Expression
+}
+
+/*
+projectedColumn is either a column name (or `*') copied as is from the source tuples or,
+when expression is set, a computed column named after its alias.
+*/
+type projectedColumn struct {
+	name       string
+	expression common.Expression
+}
+
+type selectQuery struct {
+	table     string
+	alias     string
+	joins     []joinClause
+	condition common.Expression
+	columns   []projectedColumn
+	distinct  bool
+	limit     int64
+	offset    int64
+	// optionErr is set by an option that can't be applied to the query.
+	optionErr error
+}
+
+/*
+SelectOption modifies how Select produces its result. Options are applied in order,
+so a later option overrides an earlier one of the same kind.
+*/
+type SelectOption func(*selectQuery)
+
+// Limit restricts the result to at most n rows. Scans stop as soon as n rows have been produced.
+func Limit(n int64) SelectOption {
+	return func(q *selectQuery) {
+		q.limit = n
+	}
+}
+
+// Offset skips the first n rows of the result.
+func Offset(n int64) SelectOption {
+	return func(q *selectQuery) {
+		q.offset = n
+	}
+}
+
+// Distinct eliminates duplicate rows from the result.
+func Distinct() SelectOption {
+	return func(q *selectQuery) {
+		q.distinct = true
+	}
+}
+
+// As aliases the first table of the query, so its columns are qualified with alias instead of the table name.
+func As(alias string) SelectOption {
+	return func(q *selectQuery) {
+		q.alias = alias
+	}
+}
+
+// JoinAs aliases the table of the join at position join of the command, counting from 0.
+func JoinAs(join int, alias string) SelectOption {
+	return func(q *selectQuery) {
+		if join < 0 || join >= len(q.joins) {
+			q.optionErr = fmt.Errorf("Select has no join %d to alias as `%s'", join, alias)
+			return
+		}
+		q.joins[join].alias = alias
+	}
+}
+
+func newSelectQuery(cmd *common.SelectTableCommand, options []SelectOption) (selectQuery, error) {
+	query := selectQuery{
+		condition: cmd.Condition(),
+		limit:     noLimit,
+	}
+
+	var err error
+	if query.table, err = tableName(cmd.TableName()); err != nil {
+		return query, err
+	}
+
+	for _, joinCmd := range cmd.Joins() {
+		clause := joinClause{criteria: joinCmd.FilterCriteria()}
+		if clause.table, err = tableName(joinCmd.TargetTable()); err != nil {
+			return query, err
+		}
+		query.joins = append(query.joins, clause)
+	}
+
+	for _, selector := range cmd.ProjectedColumns() {
+		column, err := newProjectedColumn(selector)
+		if err != nil {
+			return query, err
+		}
+
+		query.columns = append(query.columns, column)
+	}
+
+	for _, option := range options {
+		option(&query)
+	}
+
+	return query, query.optionErr
+}
+
+func newProjectedColumn(selector interface{}) (projectedColumn, error) {
+	switch s := selector.(type) {
+	case *common.TableColumnSelector:
+		return projectedColumn{name: s.ColumnName()}, nil
+	case *ExpressionSelector:
+		if s.Alias() == "" {
+			return projectedColumn{}, fmt.Errorf("Projected expression %v requires an alias", s.Expression())
+		}
+		return projectedColumn{name: s.Alias(), expression: s.Expression()}, nil
+	}
+
+	return projectedColumn{}, fmt.Errorf("Unrecognized projected column type %v", reflect.TypeOf(selector))
+}
+
+/*
+tableName checks that a table referenced by a command is a single name. Aliases are given
+with As and JoinAs rather than written after the name.
+*/
+func tableName(reference string) (string, error) {
+	if fields := strings.Fields(reference); len(fields) != 1 || fields[0] != reference {
+		return "", fmt.Errorf("Table name `%s' must be a single word; use As or JoinAs to alias a table", reference)
+	}
+	return reference, nil
+}
+
+// tableReference formats a table name and alias as they would be written in a query.
+func tableReference(table string, alias string) string {
+	if alias == "" || alias == table {
+		return table
+	}
+	return fmt.Sprintf("%s %s", table, alias)
+}
+
+/*
+relation returns the name the columns of the query's first table are qualified with: its
+alias if it has one and the table name otherwise.
+*/
+func (q selectQuery) relation() string {
+	if q.alias != "" {
+		return q.alias
+	}
+	return q.table
+}
+
+// queryRelation is a table or, when view is set, a view expanded for one reference in a query.
+type queryRelation struct {
+	dbTable
+	view planNode
+	// subqueries are the expressions of the view's query, which run with the context of the scan.
+	subqueries []common.Expression
+}
+
+/*
+queryTables returns the tables and views the query reads from, in the order they are
+written. Aliased references qualify their columns with the alias, so a table joined with
+itself yields tuples whose keys don't collide. Two references may not share the same name
+or alias.
+*/
+func (db *Database) queryTables(q selectQuery) (tables []queryRelation, err error) {
+	references := append([]joinClause{{table: q.table, alias: q.alias}}, q.joins...)
+	seen := map[string]bool{}
+
+	for _, reference := range references {
+		relation := reference.table
+		if reference.alias != "" {
+			relation = reference.alias
+		}
+
+		if seen[relation] {
+			return nil, fmt.Errorf("Table or alias `%s' is referenced more than once; use a different alias for each reference", relation)
+		}
+		seen[relation] = true
+
+		if view, ok := db.dbViews[reference.table]; ok {
+			expanded, err := db.viewTable(view, reference.alias)
+			if err != nil {
+				return nil, err
+			}
+
+			tables = append(tables, *expanded)
+			continue
+		}
+
+		table, err := db.table(reference.table)
+		if err != nil {
+			return nil, err
+		}
+
+		tables = append(tables, queryRelation{dbTable: table.withAlias(reference.alias)})
+	}
+
+	return tables, nil
+}
+
+// expressions returns the condition, join criteria and computed columns of the query.
+func (q selectQuery) expressions() (exprs []common.Expression) {
+	if q.condition != nil {
+		exprs = append(exprs, q.condition)
+	}
+
+	for _, clause := range q.joins {
+		if clause.criteria != nil {
+			exprs = append(exprs, clause.criteria)
+		}
+	}
+
+	for _, column := range q.columns {
+		if column.expression != nil {
+			exprs = append(exprs, column.expression)
+		}
+	}
+
+	return exprs
+}
+
+// rewrite returns a copy of the query with every expression rewritten by fn.
+func (q selectQuery) rewrite(fn func(common.Expression) common.Expression) selectQuery {
+	if q.condition != nil {
+		q.condition = rewriteExpression(q.condition, fn)
+	}
+
+	joins := []joinClause{}
+	for _, clause := range q.joins {
+		if clause.criteria != nil {
+			clause.criteria = rewriteExpression(clause.criteria, fn)
+		}
+		joins = append(joins, clause)
+	}
+	q.joins = joins
+
+	columns := []projectedColumn{}
+	for _, column := range q.columns {
+		if column.expression != nil {
+			column.expression = rewriteExpression(column.expression, fn)
+		}
+		columns = append(columns, column)
+	}
+	q.columns = columns
+
+	return q
+}
+
+func (q selectQuery) String() string {
+	columns := []string{}
+	for _, column := range q.columns {
+		if column.expression != nil {
+			columns = append(columns, fmt.Sprintf("%s AS %s", expressionString(column.expression), column.name))
+		} else {
+			columns = append(columns, column.name)
+		}
+	}
+
+	s := fmt.Sprintf("SELECT %s FROM %s", strings.Join(columns, ", "), tableReference(q.table, q.alias))
+	for _, clause := range q.joins {
+		s += fmt.Sprintf(" JOIN %s", tableReference(clause.table, clause.alias))
+		if clause.criteria != nil {
+			s += fmt.Sprintf(" ON %s", expressionString(clause.criteria))
+		}
+	}
+
+	if q.condition != nil {
+		s += fmt.Sprintf(" WHERE %s", expressionString(q.condition))
+	}
+
+	return s
+}
@@ -7,6 +7,8 @@ const (
 type dbRecordBlock struct {
 	nextRecordBlock int64
 	dbRecords       []dbRecord
+	// size is the size of the block in bytes; slotted record blocks fill it with records.
+	size int
 }
 
 func (rb *dbRecordBlock) insertRecord(record dbRecord) bool {
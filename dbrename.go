@@ -0,0 +1,131 @@
+package data
+
+import (
+	"context"
+	"fmt"
+)
+
+// RenameTableCommand renames a table, like ALTER TABLE old RENAME TO new.
+type RenameTableCommand struct {
+	oldName string
+	newName string
+}
+
+func NewRenameTableCommand(oldName string, newName string) *RenameTableCommand {
+	return &RenameTableCommand{oldName: oldName, newName: newName}
+}
+
+func (c *RenameTableCommand) OldName() string {
+	return c.oldName
+}
+
+func (c *RenameTableCommand) NewName() string {
+	return c.newName
+}
+
+func (db *Database) RenameTable(oldName string, newName string) error {
+	return db.RenameTableContext(context.Background(), oldName, newName)
+}
+
+/*
+RenameTableContext renames the table oldName to newName. Its rows, constraints, statistics
+and triggers follow it. It fails if newName is taken by a table or view, or if a view reads
+the table, since the view's query names it.
+*/
+func (db *Database) RenameTableContext(ctx context.Context, oldName string, newName string) error {
+	return db.atomically(func() error {
+		return db.renameTable(ctx, oldName, newName)
+	})
+}
+
+func (db *Database) renameTable(ctx context.Context, oldName string, newName string) error {
+	table, err := db.table(oldName)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := db.dbMaterializedViews[oldName]; ok {
+		return fmt.Errorf("Table `%s' stores a materialized view and can't be renamed", oldName)
+	}
+
+	if len(newName) > maxNameLength {
+		return fmt.Errorf("Table name `%s' can't be longer than %d bytes", newName, maxNameLength)
+	}
+
+	if _, ok := db.dbViews[newName]; ok {
+		return fmt.Errorf("View `%s' already exists in Database `%s'", newName, db.name())
+	}
+
+	if table, _ := db.table(newName); table != nil {
+		return fmt.Errorf("Duplicate table `%s' in Database `%s'", newName, db.name())
+	}
+
+	if err := db.checkNoDependentViews(oldName); err != nil {
+		return err
+	}
+
+	// Column names are qualified with the table name, so everything keyed by them is rebuilt.
+	tableName := make(dbChar, maxNameLength)
+	copy(tableName, newName)
+
+	renamed := newDBTable(table.dbTableID, tableName, []dbColumn{}, table.firstRecordBlockAddr)
+	columnNames := map[string]string{}
+	for _, column := range table.dbColumns {
+		if err := renamed.addColumn(column); err != nil {
+			return err
+		}
+		columnNames[column.name()] = concatTable(newName, trimName(column.dbColumnName))
+	}
+
+	for _, unique := range table.uniques {
+		columns := []string{}
+		for _, column := range unique.columns {
+			columns = append(columns, columnNames[column])
+		}
+		unique.columns = columns
+		renamed.uniques = append(renamed.uniques, unique)
+	}
+
+	for _, check := range table.checks {
+		columns, ok := expressionColumns(check.condition)
+		if !ok {
+			return fmt.Errorf("CHECK constraint `%s' can't be inspected to rename Table `%s'", check.name, oldName)
+		}
+
+		for _, column := range columns {
+			if qualifier, _ := splitIdentifier(column); qualifier != "" {
+				return fmt.Errorf("CHECK constraint `%s' refers to Table `%s' by name", check.name, oldName)
+			}
+		}
+
+		if check.column != "" {
+			check.column = columnNames[check.column]
+		}
+		renamed.checks = append(renamed.checks, check)
+	}
+
+	if table.statistics != nil {
+		statistics := *table.statistics
+		statistics.columns = map[string]columnStatistics{}
+		for name, column := range table.statistics.columns {
+			statistics.columns[columnNames[name]] = column
+		}
+		renamed.statistics = &statistics
+	}
+
+	if err := db.writeTableValue(ctx, *table, "TABLE_NAME", tableName); err != nil {
+		return err
+	}
+
+	// db.dbTables was copied by the journal, so a rollback restores the old table.
+	*table = renamed
+	delete(db.dbTableIDs, oldName)
+	db.dbTableIDs[newName] = renamed.dbTableID
+
+	if triggers, ok := db.dbTriggers[oldName]; ok {
+		delete(db.dbTriggers, oldName)
+		db.dbTriggers[newName] = triggers
+	}
+
+	return nil
+}
@@ -0,0 +1,580 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+)
+
+/*
+dbSequence is a sequence stored in SYS_SEQUENCES. Values are reserved sequenceCacheSize at a
+time by storing the last reserved one in RESERVED before any of them is handed out, so after
+a crash allocation resumes past every value that may have been used. Reservations survive the
+rollback of the operation making them: like in standard SQL, values are never given back.
+*/
+type dbSequence struct {
+	dbSequenceID dbInteger
+	name         string
+	start        int64
+	increment    int64
+	min          int64
+	max          int64
+	cycle        bool
+	// reserved is the last value reserved, the stored RESERVED, unless no value ever was.
+	reserved    int64
+	hasReserved bool
+	// next is the value to hand out next, among the remaining reserved ones.
+	next      int64
+	remaining int64
+}
+
+// Sequence describes a sequence.
+type Sequence struct {
+	SequenceName string
+	StartValue   int64
+	Increment    int64
+	MinValue     int64
+	MaxValue     int64
+	Cycle        bool
+}
+
+type sequenceSettings struct {
+	start, increment, min, max *int64
+	cycle                      bool
+}
+
+// SequenceOption sets a property of a sequence created by CreateSequence.
+type SequenceOption func(*sequenceSettings)
+
+// StartWith makes n the first value of the sequence. It defaults to the minimum value for
+// ascending sequences and to the maximum one for descending sequences.
+func StartWith(n int64) SequenceOption {
+	return func(s *sequenceSettings) {
+		s.start = &n
+	}
+}
+
+// IncrementBy makes the sequence advance by n, which may be negative. It defaults to 1.
+func IncrementBy(n int64) SequenceOption {
+	return func(s *sequenceSettings) {
+		s.increment = &n
+	}
+}
+
+func MinValue(n int64) SequenceOption {
+	return func(s *sequenceSettings) {
+		s.min = &n
+	}
+}
+
+func MaxValue(n int64) SequenceOption {
+	return func(s *sequenceSettings) {
+		s.max = &n
+	}
+}
+
+// Cycle makes the sequence wrap around once it reaches its maximum, or minimum if descending.
+func Cycle() SequenceOption {
+	return func(s *sequenceSettings) {
+		s.cycle = true
+	}
+}
+
+/*
+SequenceReference stands for NEXTVAL(name) or CURRVAL(name) among the values given to
+Insert and Update. NEXTVAL may also be the default value of an INTEGER column, which is
+then given the next value of the sequence when an insert omits it.
+*/
+type SequenceReference struct {
+	name    string
+	current bool
+}
+
+func NextVal(name string) SequenceReference {
+	return SequenceReference{name: name}
+}
+
+func CurrVal(name string) SequenceReference {
+	return SequenceReference{name: name, current: true}
+}
+
+func (r SequenceReference) String() string {
+	if r.current {
+		return fmt.Sprintf("CURRVAL(%s)", r.name)
+	}
+	return fmt.Sprintf("NEXTVAL(%s)", r.name)
+}
+
+// CreateSequenceCommand creates a sequence, like CREATE SEQUENCE name.
+type CreateSequenceCommand struct {
+	name    string
+	options []SequenceOption
+}
+
+func NewCreateSequenceCommand(name string, options ...SequenceOption) *CreateSequenceCommand {
+	return &CreateSequenceCommand{name: name, options: options}
+}
+
+func (c *CreateSequenceCommand) SequenceName() string {
+	return c.name
+}
+
+func (c *CreateSequenceCommand) Options() []SequenceOption {
+	return c.options
+}
+
+// DropSequenceCommand deletes a sequence, like DROP SEQUENCE name.
+type DropSequenceCommand struct {
+	name string
+}
+
+func NewDropSequenceCommand(name string) *DropSequenceCommand {
+	return &DropSequenceCommand{name: name}
+}
+
+func (c *DropSequenceCommand) SequenceName() string {
+	return c.name
+}
+
+func (db *Database) CreateSequence(name string, options ...SequenceOption) error {
+	return db.CreateSequenceContext(context.Background(), name, options...)
+}
+
+func (db *Database) CreateSequenceContext(ctx context.Context, name string, options ...SequenceOption) error {
+	return db.atomically(func() error {
+		return db.createSequence(ctx, name, options)
+	})
+}
+
+func (db *Database) createSequence(ctx context.Context, name string, options []SequenceOption) error {
+	if _, ok := db.dbSequences[name]; ok {
+		return fmt.Errorf("Duplicate sequence `%s' in Database `%s'", name, db.name())
+	}
+
+	if len(name) > maxNameLength {
+		return fmt.Errorf("Sequence name `%s' can't be longer than %d bytes", name, maxNameLength)
+	}
+
+	sequence, err := newSequence(db.nextSequenceID(), name, options)
+	if err != nil {
+		return err
+	}
+
+	if db.sequencesRecordBlock == nullBlockAddr {
+		if err := db.createSysTable(&db.sequencesRecordBlock, db.sysSequences()); err != nil {
+			return err
+		}
+	}
+
+	sequenceName := make(dbChar, maxNameLength)
+	copy(sequenceName, name)
+
+	values := map[string]dbType{
+		"SEQUENCE_ID":   sequence.dbSequenceID,
+		"START_VALUE":   dbInteger(sequence.start),
+		"INCREMENT":     dbInteger(sequence.increment),
+		"MIN_VALUE":     dbInteger(sequence.min),
+		"MAX_VALUE":     dbInteger(sequence.max),
+		"CYCLE":         dbBoolean(sequence.cycle),
+		"RESERVED":      nil,
+		"SEQUENCE_NAME": sequenceName,
+	}
+
+	if err := db.insert(ctx, db.sysSequences(), values); err != nil {
+		return err
+	}
+
+	db.dbSequences[name] = sequence
+	return nil
+}
+
+func newSequence(id dbInteger, name string, options []SequenceOption) (*dbSequence, error) {
+	settings := sequenceSettings{}
+	for _, option := range options {
+		option(&settings)
+	}
+
+	sequence := &dbSequence{dbSequenceID: id, name: name, increment: 1, min: 1, max: math.MaxInt64, cycle: settings.cycle}
+	if settings.increment != nil {
+		sequence.increment = *settings.increment
+	}
+
+	if sequence.increment == 0 {
+		return nil, fmt.Errorf("Sequence `%s' can't have an increment of 0", name)
+	} else if sequence.increment < 0 {
+		sequence.min, sequence.max = math.MinInt64, -1
+	}
+
+	if settings.min != nil {
+		sequence.min = *settings.min
+	}
+
+	if settings.max != nil {
+		sequence.max = *settings.max
+	}
+
+	sequence.start = sequence.min
+	if sequence.increment < 0 {
+		sequence.start = sequence.max
+	}
+
+	if settings.start != nil {
+		sequence.start = *settings.start
+	}
+
+	if sequence.min > sequence.max {
+		return nil, fmt.Errorf("Sequence `%s' has a minimum value greater than its maximum value", name)
+	}
+
+	if sequence.start < sequence.min || sequence.start > sequence.max {
+		return nil, fmt.Errorf("Start value %d of sequence `%s' is out of range [%d, %d]", sequence.start, name, sequence.min, sequence.max)
+	}
+
+	return sequence, nil
+}
+
+func (db *Database) DropSequence(name string) error {
+	return db.DropSequenceContext(context.Background(), name)
+}
+
+func (db *Database) DropSequenceContext(ctx context.Context, name string) error {
+	return db.atomically(func() error {
+		return db.dropSequence(ctx, name)
+	})
+}
+
+func (db *Database) dropSequence(ctx context.Context, name string) error {
+	sequence, ok := db.dbSequences[name]
+	if !ok {
+		return fmt.Errorf("Sequence `%s' does not exist in Database `%s'", name, db.name())
+	}
+
+	for _, table := range db.dbTables {
+		for _, column := range table.dbColumns {
+			if !column.hasConstraint(dbSequenceDefaultConstraint) {
+				continue
+			}
+
+			id, err := db.defaultSequenceID(column)
+			if err != nil {
+				return err
+			}
+
+			if id == sequence.dbSequenceID {
+				return fmt.Errorf("Sequence `%s' is the default value of column `%s'", name, column.name())
+			}
+		}
+	}
+
+	condition := dropCondition("SYS_SEQUENCES", "SEQUENCE_ID", int64(sequence.dbSequenceID))
+	if err := db.delete(ctx, db.sysSequences(), condition, nil); err != nil {
+		return err
+	}
+
+	delete(db.dbSequences, name)
+	delete(db.currentValues, name)
+	return nil
+}
+
+// NextValue advances the sequence name and returns its new value, like NEXTVAL(name).
+func (db *Database) NextValue(name string) (int64, error) {
+	return db.NextValueContext(context.Background(), name)
+}
+
+func (db *Database) NextValueContext(ctx context.Context, name string) (value int64, err error) {
+	err = db.atomically(func() error {
+		value, err = db.nextValue(ctx, name)
+		return err
+	})
+	return value, err
+}
+
+// CurrentValue returns the value last returned by NextValue for the sequence name, like CURRVAL(name).
+func (db *Database) CurrentValue(name string) (int64, error) {
+	if _, ok := db.dbSequences[name]; !ok {
+		return 0, fmt.Errorf("Sequence `%s' does not exist in Database `%s'", name, db.name())
+	}
+
+	value, ok := db.currentValues[name]
+	if !ok {
+		return 0, fmt.Errorf("CURRVAL of sequence `%s' is not yet defined", name)
+	}
+	return value, nil
+}
+
+func (db *Database) nextValue(ctx context.Context, name string) (int64, error) {
+	sequence, ok := db.dbSequences[name]
+	if !ok {
+		return 0, fmt.Errorf("Sequence `%s' does not exist in Database `%s'", name, db.name())
+	}
+
+	if sequence.remaining == 0 {
+		if err := db.reserveValues(ctx, sequence); err != nil {
+			return 0, err
+		}
+	}
+
+	value := sequence.next
+	sequence.remaining--
+	if sequence.remaining > 0 {
+		sequence.next, _ = sequence.advance(value)
+	}
+
+	db.currentValues[name] = value
+	return value, nil
+}
+
+// advance returns the value following v, or false if the sequence is exhausted.
+func (s *dbSequence) advance(v int64) (int64, bool) {
+	if s.increment > 0 && v > s.max-s.increment {
+		return s.min, s.cycle
+	}
+
+	if s.increment < 0 && v < s.min-s.increment {
+		return s.max, s.cycle
+	}
+
+	return v + s.increment, true
+}
+
+/*
+reserveValues reserves the next sequenceCacheSize values of the sequence, or as many as are
+left, and stores the last of them before returning.
+*/
+func (db *Database) reserveValues(ctx context.Context, sequence *dbSequence) error {
+	first := sequence.start
+	if sequence.hasReserved {
+		var ok bool
+		if first, ok = sequence.advance(sequence.reserved); !ok {
+			return fmt.Errorf("Sequence `%s' has no values left", sequence.name)
+		}
+	}
+
+	last, count := first, int64(1)
+	for ; count < sequenceCacheSize; count++ {
+		next, ok := sequence.advance(last)
+		if !ok {
+			break
+		}
+		last = next
+	}
+
+	if err := db.storeReservation(ctx, sequence, last); err != nil {
+		return err
+	}
+
+	sequence.reserved, sequence.hasReserved = last, true
+	sequence.next, sequence.remaining = first, count
+	return nil
+}
+
+/*
+storeReservation stores reserved in the RESERVED column of the sequence and syncs the file.
+The write bypasses the journal, and the journaled copy of the block, if any, is updated
+too, so that rolling back the operation that reserved the values doesn't undo it.
+*/
+func (db *Database) storeReservation(ctx context.Context, sequence *dbSequence, reserved int64) error {
+	sysSequences := db.sysSequences()
+	column, err := sysSequences.column("RESERVED")
+	if err != nil {
+		return err
+	}
+
+	update := func(rb *dbRecordBlock) bool {
+		for i := range rb.dbRecords {
+			if !rb.dbRecords[i].isFree() && rb.dbRecords[i].dbTuple["SYS_SEQUENCES.SEQUENCE_ID"] == sequence.dbSequenceID {
+				rb.dbRecords[i].insertColumnValue(dbInteger(reserved), *column)
+				return true
+			}
+		}
+		return false
+	}
+
+	found := false
+	err = db.scanRecordBlocks(ctx, sysSequences, func(addr int64, rb dbRecordBlock) (bool, error) {
+		if found = update(&rb); !found {
+			return true, nil
+		}
+
+		journal := db.journal
+		db.journal = nil
+		err := db.writeAt(sysSequences.recordBlockBytes(rb), addr)
+		db.journal = journal
+		if err != nil {
+			return false, err
+		}
+
+		if journal != nil {
+			if saved, ok := journal.blocks[addr]; ok {
+				rb := sysSequences.loadRecordBlockBytes(saved)
+				if update(&rb) {
+					journal.blocks[addr] = sysSequences.recordBlockBytes(rb)
+				}
+			}
+		}
+
+		return false, db.dbFile.Sync()
+	})
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return fmt.Errorf("Sequence `%s' is missing from SYS_SEQUENCES", sequence.name)
+	}
+	return nil
+}
+
+/*
+resolveSequences replaces the sequence references among values by their values. With
+defaults, the columns missing from values whose default is NEXTVAL are given one. Every
+NEXTVAL of the row is evaluated before its CURRVALs, so those see the row's new values.
+*/
+func (db *Database) resolveSequences(ctx context.Context, table dbTable, values map[string]interface{}, defaults bool) (map[string]interface{}, error) {
+	resolved := map[string]interface{}{}
+	given := map[string]bool{}
+	for key, value := range values {
+		given[qualifiedIdentifier(table, key)] = true
+
+		if reference, ok := value.(SequenceReference); ok && !reference.current {
+			var err error
+			if value, err = db.nextValue(ctx, reference.name); err != nil {
+				return nil, err
+			}
+		}
+		resolved[key] = value
+	}
+
+	for _, column := range table.dbColumns {
+		if !defaults || given[column.name()] || !column.hasConstraint(dbSequenceDefaultConstraint) {
+			continue
+		}
+
+		id, err := db.defaultSequenceID(column)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, sequence := range db.dbSequences {
+			if sequence.dbSequenceID != id {
+				continue
+			}
+
+			if resolved[column.name()], err = db.nextValue(ctx, sequence.name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for key, value := range resolved {
+		if reference, ok := value.(SequenceReference); ok {
+			var err error
+			if resolved[key], err = db.CurrentValue(reference.name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return resolved, nil
+}
+
+// newSequenceDefault stores NEXTVAL of a sequence as the default value of column.
+func (db *Database) newSequenceDefault(ctx context.Context, column dbColumn, reference SequenceReference) (dbInteger, error) {
+	if reference.current {
+		return 0, fmt.Errorf("Default value of column `%s' can't be %s", column.name(), reference)
+	}
+
+	if column.dbTypeID != dbIntegerTypeID {
+		return 0, fmt.Errorf("Column `%s' is not of type INTEGER", column.name())
+	}
+
+	sequence, ok := db.dbSequences[reference.name]
+	if !ok {
+		return 0, fmt.Errorf("Sequence `%s' does not exist in Database `%s'", reference.name, db.name())
+	}
+
+	return db.newDefaultNumeric(ctx, sequence.dbSequenceID)
+}
+
+// defaultSequenceID returns the ID of the sequence giving column its default values.
+func (db *Database) defaultSequenceID(column dbColumn) (dbInteger, error) {
+	set, err := db.tableSet(db.sysNumerics())
+	if err != nil {
+		return 0, err
+	}
+
+	for _, row := range set {
+		if row["SYS_DEFAULT_NUMERICS.VALUE_ID"] == column.dbDefaultValueConstraintID {
+			id, _ := row["SYS_DEFAULT_NUMERICS.VALUE"].(dbInteger)
+			return id, nil
+		}
+	}
+
+	return 0, fmt.Errorf("Default value of column `%s' is missing", column.name())
+}
+
+func (db Database) AllSequences() []*Sequence {
+	sequences := []*Sequence{}
+	for _, s := range db.dbSequences {
+		sequences = append(sequences, &Sequence{
+			SequenceName: s.name,
+			StartValue:   s.start,
+			Increment:    s.increment,
+			MinValue:     s.min,
+			MaxValue:     s.max,
+			Cycle:        s.cycle,
+		})
+	}
+
+	sort.Slice(sequences, func(i, j int) bool {
+		return sequences[i].SequenceName < sequences[j].SequenceName
+	})
+	return sequences
+}
+
+func (db Database) sysSequences() dbTable {
+	return newSequencesSysTable(dbInteger(db.sequencesRecordBlock))
+}
+
+func (db Database) nextSequenceID() dbInteger {
+	id := dbInteger(0)
+	for _, sequence := range db.dbSequences {
+		if sequence.dbSequenceID > id {
+			id = sequence.dbSequenceID
+		}
+	}
+	return id + 1
+}
+
+// loadSequences reads the sequences stored in SYS_SEQUENCES.
+func (db *Database) loadSequences() error {
+	if db.sequencesRecordBlock == nullBlockAddr {
+		return nil
+	}
+
+	set, err := db.tableSet(db.sysSequences())
+	if err != nil {
+		return err
+	}
+
+	for _, row := range set {
+		sequence := &dbSequence{
+			dbSequenceID: row["SYS_SEQUENCES.SEQUENCE_ID"].(dbInteger),
+			name:         trimName(row["SYS_SEQUENCES.SEQUENCE_NAME"].(dbChar)),
+			start:        int64(row["SYS_SEQUENCES.START_VALUE"].(dbInteger)),
+			increment:    int64(row["SYS_SEQUENCES.INCREMENT"].(dbInteger)),
+			min:          int64(row["SYS_SEQUENCES.MIN_VALUE"].(dbInteger)),
+			max:          int64(row["SYS_SEQUENCES.MAX_VALUE"].(dbInteger)),
+			cycle:        bool(row["SYS_SEQUENCES.CYCLE"].(dbBoolean)),
+		}
+
+		if reserved, ok := row["SYS_SEQUENCES.RESERVED"].(dbInteger); ok {
+			sequence.reserved, sequence.hasReserved = int64(reserved), true
+		}
+
+		db.dbSequences[sequence.name] = sequence
+	}
+
+	return nil
+}
@@ -0,0 +1,338 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/modest-sql/common"
+)
+
+/*
+expressionNode is the stored form of an expression. Only the expressions of this package
+can be stored; those of package common can't be inspected and are rejected.
+*/
+type expressionNode struct {
+	Kind     string            `json:"kind"`
+	Name     string            `json:"name,omitempty"`
+	Type     string            `json:"type,omitempty"`
+	Value    string            `json:"value,omitempty"`
+	Operator uint8             `json:"operator,omitempty"`
+	Negated  bool              `json:"negated,omitempty"`
+	Operands []*expressionNode `json:"operands,omitempty"`
+	Subquery *queryNode        `json:"subquery,omitempty"`
+	Subkind  subqueryKind      `json:"subkind,omitempty"`
+}
+
+type queryNode struct {
+	Table     string           `json:"table"`
+	Alias     string           `json:"alias,omitempty"`
+	Joins     []joinNodeClause `json:"joins,omitempty"`
+	Condition *expressionNode  `json:"condition,omitempty"`
+	Columns   []projectedNode  `json:"columns"`
+	Distinct  bool             `json:"distinct,omitempty"`
+	Limit     int64            `json:"limit"`
+	Offset    int64            `json:"offset,omitempty"`
+}
+
+type joinNodeClause struct {
+	Table    string          `json:"table"`
+	Alias    string          `json:"alias,omitempty"`
+	Criteria *expressionNode `json:"criteria,omitempty"`
+}
+
+type projectedNode struct {
+	Name       string          `json:"name"`
+	Expression *expressionNode `json:"expression,omitempty"`
+}
+
+// marshalQuery serializes a select query so it can be stored in the catalog.
+func marshalQuery(query selectQuery) (string, error) {
+	node, err := encodeQuery(query)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := json.Marshal(node)
+	return string(b), err
+}
+
+// unmarshalQuery rebuilds a select query stored by marshalQuery.
+func (db *Database) unmarshalQuery(s string) (selectQuery, error) {
+	node := &queryNode{}
+	if err := json.Unmarshal([]byte(s), node); err != nil {
+		return selectQuery{}, err
+	}
+
+	return db.decodeQuery(node)
+}
+
+// marshalExpression serializes an expression so it can be stored in the catalog.
+func marshalExpression(expr common.Expression) (string, error) {
+	node, err := encodeExpression(expr)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := json.Marshal(node)
+	return string(b), err
+}
+
+// unmarshalExpression rebuilds an expression stored by marshalExpression.
+func (db *Database) unmarshalExpression(s string) (common.Expression, error) {
+	node := &expressionNode{}
+	if err := json.Unmarshal([]byte(s), node); err != nil {
+		return nil, err
+	}
+
+	return db.decodeExpression(node)
+}
+
+func encodeQuery(query selectQuery) (*queryNode, error) {
+	node := &queryNode{
+		Table:    query.table,
+		Alias:    query.alias,
+		Columns:  []projectedNode{},
+		Distinct: query.distinct,
+		Limit:    query.limit,
+		Offset:   query.offset,
+	}
+
+	var err error
+	if node.Condition, err = encodeExpression(query.condition); err != nil {
+		return nil, err
+	}
+
+	for _, clause := range query.joins {
+		criteria, err := encodeExpression(clause.criteria)
+		if err != nil {
+			return nil, err
+		}
+
+		node.Joins = append(node.Joins, joinNodeClause{Table: clause.table, Alias: clause.alias, Criteria: criteria})
+	}
+
+	for _, column := range query.columns {
+		expression, err := encodeExpression(column.expression)
+		if err != nil {
+			return nil, err
+		}
+
+		node.Columns = append(node.Columns, projectedNode{Name: column.name, Expression: expression})
+	}
+
+	return node, nil
+}
+
+func (db *Database) decodeQuery(node *queryNode) (query selectQuery, err error) {
+	query = selectQuery{
+		table:    node.Table,
+		alias:    node.Alias,
+		distinct: node.Distinct,
+		limit:    node.Limit,
+		offset:   node.Offset,
+	}
+
+	if query.condition, err = db.decodeExpression(node.Condition); err != nil {
+		return query, err
+	}
+
+	for _, clause := range node.Joins {
+		criteria, err := db.decodeExpression(clause.Criteria)
+		if err != nil {
+			return query, err
+		}
+
+		query.joins = append(query.joins, joinClause{table: clause.Table, alias: clause.Alias, criteria: criteria})
+	}
+
+	for _, column := range node.Columns {
+		expression, err := db.decodeExpression(column.Expression)
+		if err != nil {
+			return query, err
+		}
+
+		query.columns = append(query.columns, projectedColumn{name: column.Name, expression: expression})
+	}
+
+	return query, nil
+}
+
+func encodeExpression(expr common.Expression) (*expressionNode, error) {
+	if expr == nil {
+		return nil, nil
+	}
+
+	encode := func(kind string, operands ...common.Expression) (*expressionNode, error) {
+		node := &expressionNode{Kind: kind}
+		for _, operand := range operands {
+			encoded, err := encodeExpression(operand)
+			if err != nil {
+				return nil, err
+			}
+			node.Operands = append(node.Operands, encoded)
+		}
+		return node, nil
+	}
+
+	switch e := expr.(type) {
+	case *ColumnExpression:
+		return &expressionNode{Kind: "column", Name: e.name}, nil
+	case *LiteralExpression:
+		return encodeLiteral(e.value)
+	case *ArithmeticExpression:
+		node, err := encode("arithmetic", e.left, e.right)
+		if node != nil {
+			node.Operator = uint8(e.operator)
+		}
+		return node, err
+	case *ConcatExpression:
+		return encode("concat", e.left, e.right)
+	case *FunctionExpression:
+		node, err := encode("function", e.arguments...)
+		if node != nil {
+			node.Name = e.name
+		}
+		return node, err
+	case *ComparisonExpression:
+		node, err := encode("comparison", e.left, e.right)
+		if node != nil {
+			node.Operator = uint8(e.operator)
+		}
+		return node, err
+	case *AndExpression:
+		return encode("and", e.left, e.right)
+	case *OrExpression:
+		return encode("or", e.left, e.right)
+	case *NotExpression:
+		return encode("not", e.operand)
+	case *IsNullExpression:
+		node, err := encode("isnull", e.operand)
+		if node != nil {
+			node.Negated = e.negated
+		}
+		return node, err
+	case *SubqueryExpression:
+		node, err := encode("subquery")
+		if err != nil {
+			return nil, err
+		}
+
+		if e.operand != nil {
+			operand, err := encodeExpression(e.operand)
+			if err != nil {
+				return nil, err
+			}
+			node.Operands = []*expressionNode{operand}
+		}
+
+		node.Subkind = e.kind
+		node.Subquery, err = encodeQuery(e.query)
+		return node, err
+	}
+
+	return nil, fmt.Errorf("Expression %s can't be stored", expressionString(expr))
+}
+
+func encodeLiteral(value interface{}) (*expressionNode, error) {
+	node := &expressionNode{Kind: "literal"}
+
+	switch v := value.(type) {
+	case nil:
+		node.Type = "null"
+	case int64:
+		node.Type, node.Value = "integer", strconv.FormatInt(v, 10)
+	case float64:
+		node.Type, node.Value = "float", strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		node.Type, node.Value = "boolean", strconv.FormatBool(v)
+	case string:
+		node.Type, node.Value = "char", v
+	default:
+		return nil, fmt.Errorf("Literal of type %T can't be stored", value)
+	}
+
+	return node, nil
+}
+
+func (db *Database) decodeExpression(node *expressionNode) (common.Expression, error) {
+	if node == nil {
+		return nil, nil
+	}
+
+	operands := []common.Expression{}
+	for _, operand := range node.Operands {
+		decoded, err := db.decodeExpression(operand)
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, decoded)
+	}
+
+	arity := map[string]int{
+		"arithmetic": 2, "concat": 2, "comparison": 2, "and": 2, "or": 2, "not": 1, "isnull": 1,
+	}
+	if n, ok := arity[node.Kind]; ok && len(operands) != n {
+		return nil, fmt.Errorf("Stored %s expression has %d operands", node.Kind, len(operands))
+	}
+
+	switch node.Kind {
+	case "column":
+		return NewColumnExpression(node.Name), nil
+	case "literal":
+		return decodeLiteral(node)
+	case "arithmetic":
+		return NewArithmeticExpression(ArithmeticOperator(node.Operator), operands[0], operands[1]), nil
+	case "concat":
+		return NewConcatExpression(operands[0], operands[1]), nil
+	case "function":
+		return NewFunctionExpression(node.Name, operands...)
+	case "comparison":
+		return NewComparisonExpression(ComparisonOperator(node.Operator), operands[0], operands[1]), nil
+	case "and":
+		return NewAndExpression(operands[0], operands[1]), nil
+	case "or":
+		return NewOrExpression(operands[0], operands[1]), nil
+	case "not":
+		return NewNotExpression(operands[0]), nil
+	case "isnull":
+		return NewIsNullExpression(operands[0], node.Negated), nil
+	case "subquery":
+		if node.Subquery == nil {
+			return nil, fmt.Errorf("Stored subquery has no query")
+		}
+
+		query, err := db.decodeQuery(node.Subquery)
+		if err != nil {
+			return nil, err
+		}
+
+		var operand common.Expression
+		if len(operands) > 0 {
+			operand = operands[0]
+		}
+		return db.subquery(node.Subkind, operand, query)
+	}
+
+	return nil, fmt.Errorf("Unknown stored expression kind `%s'", node.Kind)
+}
+
+func decodeLiteral(node *expressionNode) (common.Expression, error) {
+	switch node.Type {
+	case "null":
+		return NewLiteralExpression(nil), nil
+	case "integer":
+		v, err := strconv.ParseInt(node.Value, 10, 64)
+		return NewLiteralExpression(v), err
+	case "float":
+		v, err := strconv.ParseFloat(node.Value, 64)
+		return NewLiteralExpression(v), err
+	case "boolean":
+		v, err := strconv.ParseBool(node.Value)
+		return NewLiteralExpression(v), err
+	case "char":
+		return NewLiteralExpression(node.Value), nil
+	}
+
+	return nil, fmt.Errorf("Unknown stored literal type `%s'", node.Type)
+}
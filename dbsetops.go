@@ -0,0 +1,282 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/modest-sql/common"
+)
+
+type SetOperator uint8
+
+const (
+	Union SetOperator = iota
+	UnionAll
+	Intersect
+	Except
+)
+
+var setOperatorNames = map[SetOperator]string{
+	Union:     "Union",
+	UnionAll:  "Union All",
+	Intersect: "Intersect",
+	Except:    "Except",
+}
+
+/*
+setOperationNode combines the rows of two plans with the same number of compatible
+columns. Rows of right are renamed after the columns of left, position by position.
+*/
+type setOperationNode struct {
+	operator SetOperator
+	left     planNode
+	right    planNode
+}
+
+func (n *setOperationNode) run(ctx context.Context, db *Database, emit tupleEmitter) error {
+	switch n.operator {
+	case UnionAll:
+		return n.runUnionAll(ctx, db, emit)
+	case Union:
+		return (&distinctNode{input: &setOperationNode{operator: UnionAll, left: n.left, right: n.right}}).run(ctx, db, emit)
+	}
+
+	rightKeys := map[string]bool{}
+	err := n.runRight(ctx, db, func(tuple dbTuple) (bool, error) {
+		rightKeys[tupleKey(tuple)] = true
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	filtered := &filterFuncNode{input: n.left, keep: func(tuple dbTuple) bool {
+		return rightKeys[tupleKey(tuple)] == (n.operator == Intersect)
+	}}
+
+	return (&distinctNode{input: filtered}).run(ctx, db, emit)
+}
+
+func (n *setOperationNode) runUnionAll(ctx context.Context, db *Database, emit tupleEmitter) error {
+	stopped := false
+	err := n.left.run(ctx, db, func(tuple dbTuple) (bool, error) {
+		more, err := emit(tuple)
+		stopped = !more
+		return more, err
+	})
+	if err != nil || stopped {
+		return err
+	}
+
+	return n.runRight(ctx, db, emit)
+}
+
+func (n *setOperationNode) runRight(ctx context.Context, db *Database, emit tupleEmitter) error {
+	leftColumns, rightColumns := n.left.columns(), n.right.columns()
+
+	return n.right.run(ctx, db, func(tuple dbTuple) (bool, error) {
+		renamed := dbTuple{}
+		for i := range rightColumns {
+			renamed[leftColumns[i].name] = tuple[rightColumns[i].name]
+		}
+
+		return emit(renamed)
+	})
+}
+
+func (n *setOperationNode) columns() []planColumn {
+	columns := n.left.columns()
+	for i, column := range n.right.columns() {
+		columns[i].nullable = columns[i].nullable || column.nullable
+		if column.size > columns[i].size {
+			columns[i].size = column.size
+		}
+	}
+	return columns
+}
+
+func (n *setOperationNode) estimate() int64 {
+	switch n.operator {
+	case Union, UnionAll:
+		return n.left.estimate() + n.right.estimate()
+	}
+	return n.left.estimate()
+}
+
+func (n *setOperationNode) explain() (string, string) {
+	return setOperatorNames[n.operator], ""
+}
+
+func (n *setOperationNode) inputs() []*planNode {
+	return []*planNode{&n.left, &n.right}
+}
+
+// filterFuncNode keeps the tuples of input for which keep returns true.
+type filterFuncNode struct {
+	input planNode
+	keep  func(dbTuple) bool
+}
+
+func (n *filterFuncNode) run(ctx context.Context, db *Database, emit tupleEmitter) error {
+	return n.input.run(ctx, db, func(tuple dbTuple) (bool, error) {
+		if !n.keep(tuple) {
+			return true, nil
+		}
+		return emit(tuple)
+	})
+}
+
+func (n *filterFuncNode) columns() []planColumn {
+	return n.input.columns()
+}
+
+func (n *filterFuncNode) estimate() int64 {
+	return n.input.estimate()
+}
+
+func (n *filterFuncNode) explain() (string, string) {
+	return "Filter", ""
+}
+
+func (n *filterFuncNode) inputs() []*planNode {
+	return []*planNode{&n.input}
+}
+
+// widenNode converts the INTEGER values of the named columns of input to FLOAT.
+type widenNode struct {
+	input planNode
+	names map[string]bool
+}
+
+func (n *widenNode) run(ctx context.Context, db *Database, emit tupleEmitter) error {
+	return n.input.run(ctx, db, func(tuple dbTuple) (bool, error) {
+		widened := dbTuple{}
+		for name, value := range tuple {
+			if v, ok := value.(dbInteger); ok && n.names[name] {
+				value = dbFloat(v)
+			}
+			widened[name] = value
+		}
+		return emit(widened)
+	})
+}
+
+func (n *widenNode) columns() []planColumn {
+	columns := n.input.columns()
+	for i := range columns {
+		if n.names[columns[i].name] {
+			columns[i].typeID = dbFloatTypeID
+		}
+	}
+	return columns
+}
+
+func (n *widenNode) estimate() int64 {
+	return n.input.estimate()
+}
+
+func (n *widenNode) explain() (string, string) {
+	names := []string{}
+	for name := range n.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return "Widen", strings.Join(names, ", ")
+}
+
+func (n *widenNode) inputs() []*planNode {
+	return []*planNode{&n.input}
+}
+
+func (db *Database) SetOperation(operator SetOperator, left *common.SelectTableCommand, right *common.SelectTableCommand) (*ResultSet, error) {
+	return db.SetOperationContext(context.Background(), operator, left, right)
+}
+
+/*
+SetOperationContext combines the results of two select commands with UNION, UNION ALL,
+INTERSECT or EXCEPT. Both commands must project the same number of columns with compatible
+types; the result is named after the columns of left.
+*/
+func (db *Database) SetOperationContext(ctx context.Context, operator SetOperator, left *common.SelectTableCommand, right *common.SelectTableCommand) (*ResultSet, error) {
+	leftQuery, err := newSelectQuery(left, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	rightQuery, err := newSelectQuery(right, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := db.planSetOperation(ctx, operator, leftQuery, rightQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.resultSet(ctx, plan)
+}
+
+func (db *Database) planSetOperation(ctx context.Context, operator SetOperator, left selectQuery, right selectQuery) (planNode, error) {
+	if _, ok := setOperatorNames[operator]; !ok {
+		return nil, fmt.Errorf("Unknown set operator %d", operator)
+	}
+
+	resetSubqueries(ctx, append(left.expressions(), right.expressions()...)...)
+
+	leftPlan, err := db.plan(left)
+	if err != nil {
+		return nil, err
+	}
+
+	rightPlan, err := db.plan(right)
+	if err != nil {
+		return nil, err
+	}
+
+	leftColumns, rightColumns := leftPlan.columns(), rightPlan.columns()
+	if len(leftColumns) != len(rightColumns) {
+		return nil, fmt.Errorf("%s requires the same number of columns on both sides, got %d and %d", setOperatorNames[operator], len(leftColumns), len(rightColumns))
+	}
+
+	leftWidened, rightWidened := map[string]bool{}, map[string]bool{}
+	for i := range leftColumns {
+		if !compatibleColumns(leftColumns[i], rightColumns[i]) {
+			return nil, fmt.Errorf("Column `%s' is not compatible with column `%s' in %s", leftColumns[i].name, rightColumns[i].name, setOperatorNames[operator])
+		}
+
+		if mixedNumeric(leftColumns[i], rightColumns[i]) {
+			leftWidened[leftColumns[i].name] = true
+			rightWidened[rightColumns[i].name] = true
+		}
+	}
+
+	// 1 and 1.0 must share a key, so INTEGER columns meeting FLOAT columns are widened on both sides.
+	if len(leftWidened) > 0 {
+		leftPlan = &widenNode{input: leftPlan, names: leftWidened}
+		rightPlan = &widenNode{input: rightPlan, names: rightWidened}
+	}
+
+	return &setOperationNode{operator: operator, left: leftPlan, right: rightPlan}, nil
+}
+
+// mixedNumeric reports whether one of a and b is INTEGER and the other FLOAT.
+func mixedNumeric(a planColumn, b planColumn) bool {
+	return a.typed && b.typed && a.typeID != b.typeID &&
+		(a.typeID == dbIntegerTypeID || a.typeID == dbFloatTypeID) &&
+		(b.typeID == dbIntegerTypeID || b.typeID == dbFloatTypeID)
+}
+
+// compatibleColumns reports whether values of a and b can be combined. INTEGER and FLOAT are compatible, and so are CHAR and VARCHAR.
+func compatibleColumns(a planColumn, b planColumn) bool {
+	if !a.typed || !b.typed || a.typeID == b.typeID {
+		return true
+	}
+
+	numeric := func(id dbTypeID) bool {
+		return id == dbIntegerTypeID || id == dbFloatTypeID
+	}
+
+	return (numeric(a.typeID) && numeric(b.typeID)) || (textType(a.typeID) && textType(b.typeID))
+}
@@ -0,0 +1,207 @@
+package data
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+Tables with VARCHAR columns store their rows in slotted record blocks. After the next block
+address, the header holds the number of slots and the offset where row data starts. The
+slot directory follows, one offset and length per slot, and rows are packed from the end of
+the block towards it, leaving the free space in between. A slot with offset 0 is free.
+
+A row is its null bitmap followed by its values in column order: fixed size values take the
+size of their type, and VARCHAR values a length prefix and their bytes. Blocks are always
+written whole, so the free space of a block is compacted every time it is written.
+*/
+const (
+	slottedHeaderSize = 16
+	slotSize          = 8
+	varcharPrefixSize = 2
+)
+
+// slotted tells if the table is stored in slotted record blocks.
+func (t dbTable) slotted() bool {
+	for _, column := range t.dbColumns {
+		if column.dbTypeID == dbVarcharTypeID {
+			return true
+		}
+	}
+	return false
+}
+
+// storedSize returns the bytes record takes in a slotted record block, not counting its slot.
+func (t dbTable) storedSize(record dbRecord) int {
+	size := bitmapSize(len(t.dbColumns))
+	for _, column := range t.dbColumns {
+		if column.dbTypeID != dbVarcharTypeID {
+			size += int(column.dbTypeSize)
+		} else if value, ok := record.columnValue(column).(dbChar); ok {
+			size += varcharPrefixSize + len(bytes.TrimRight(value, "\x00"))
+		} else {
+			size += varcharPrefixSize
+		}
+	}
+	return size
+}
+
+// slotCount returns the number of slots written for rb: free slots after the last row are dropped.
+func (t dbTable) slotCount(rb dbRecordBlock) int {
+	for i := len(rb.dbRecords) - 1; i >= 0; i-- {
+		if !rb.dbRecords[i].isFree() {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// usedSpace returns the bytes taken by the header, slot directory and rows of a slotted block.
+func (t dbTable) usedSpace(rb dbRecordBlock) int {
+	used := slottedHeaderSize + t.slotCount(rb)*slotSize
+	for _, record := range rb.dbRecords {
+		if !record.isFree() {
+			used += t.storedSize(record)
+		}
+	}
+	return used
+}
+
+// fits tells if the rows of rb can be written to it. Fixed size record blocks always fit.
+func (t dbTable) fits(rb dbRecordBlock) bool {
+	return !t.slotted() || t.usedSpace(rb) <= rb.size
+}
+
+// checkRecordFits fails if record doesn't fit in an empty record block of the table.
+func (t dbTable) checkRecordFits(record dbRecord, blockSize int64) error {
+	if !t.slotted() {
+		return nil
+	}
+
+	if size := slottedHeaderSize + slotSize + t.storedSize(record); int64(size) > blockSize {
+		return fmt.Errorf("Row of %d bytes doesn't fit in a record block of Table `%s'", size, t.name())
+	}
+	return nil
+}
+
+// insertSlottedRecord stores record in the first free slot of rb, or a new one, if there is room.
+func (t dbTable) insertSlottedRecord(rb *dbRecordBlock, record dbRecord) bool {
+	slot := len(rb.dbRecords)
+	for i := range rb.dbRecords {
+		if rb.dbRecords[i].isFree() {
+			slot = i
+			break
+		}
+	}
+
+	if slot == len(rb.dbRecords) {
+		rb.dbRecords = append(rb.dbRecords, t.newDBRecord())
+	}
+
+	free := rb.dbRecords[slot]
+	rb.dbRecords[slot] = record
+	if t.fits(*rb) {
+		return true
+	}
+
+	rb.dbRecords[slot] = free
+	if slot == t.slotCount(*rb) && slot == len(rb.dbRecords)-1 {
+		rb.dbRecords = rb.dbRecords[:slot]
+	}
+	return false
+}
+
+func (t dbTable) slottedRecordBlockBytes(rb dbRecordBlock) []byte {
+	b := make([]byte, rb.size)
+	binary.LittleEndian.PutUint64(b, uint64(rb.nextRecordBlock))
+
+	slots := t.slotCount(rb)
+	dataStart := rb.size
+	for i, record := range rb.dbRecords[:slots] {
+		if record.isFree() {
+			continue
+		}
+
+		row := t.slottedRecordBytes(record)
+		dataStart -= len(row)
+		copy(b[dataStart:], row)
+
+		slot := b[slottedHeaderSize+i*slotSize:]
+		binary.LittleEndian.PutUint32(slot, uint32(dataStart))
+		binary.LittleEndian.PutUint32(slot[4:], uint32(len(row)))
+	}
+
+	binary.LittleEndian.PutUint32(b[8:], uint32(slots))
+	binary.LittleEndian.PutUint32(b[12:], uint32(dataStart))
+	return b
+}
+
+func (t dbTable) slottedRecordBytes(record dbRecord) []byte {
+	b := append([]byte{}, record.nulls...)
+	for _, column := range t.dbColumns {
+		value := record.columnValue(column)
+
+		if column.dbTypeID != dbVarcharTypeID {
+			if value == nil {
+				b = append(b, make([]byte, column.dbTypeSize)...)
+			} else {
+				b = append(b, value.bytes()...)
+			}
+			continue
+		}
+
+		var text []byte
+		if value != nil {
+			text = bytes.TrimRight(value.bytes(), "\x00")
+		}
+
+		prefix := make([]byte, varcharPrefixSize)
+		binary.LittleEndian.PutUint16(prefix, uint16(len(text)))
+		b = append(append(b, prefix...), text...)
+	}
+	return b
+}
+
+func (t dbTable) loadSlottedRecordBlockBytes(b []byte) dbRecordBlock {
+	rb := dbRecordBlock{nextRecordBlock: int64(binary.LittleEndian.Uint64(b)), size: len(b)}
+
+	slots := int(binary.LittleEndian.Uint32(b[8:]))
+	for i := 0; i < slots; i++ {
+		slot := b[slottedHeaderSize+i*slotSize:]
+		offset := int(binary.LittleEndian.Uint32(slot))
+		length := int(binary.LittleEndian.Uint32(slot[4:]))
+
+		if offset == 0 {
+			rb.dbRecords = append(rb.dbRecords, t.newDBRecord())
+			continue
+		}
+		rb.dbRecords = append(rb.dbRecords, t.loadSlottedRecordBytes(b[offset:offset+length]))
+	}
+
+	return rb
+}
+
+func (t dbTable) loadSlottedRecordBytes(b []byte) dbRecord {
+	record := dbRecord{nulls: newBitmap(len(t.dbColumns)), dbTuple: dbTuple{}}
+	copy(record.nulls, b)
+	b = b[len(record.nulls):]
+
+	for i, column := range t.dbColumns {
+		size := int(column.dbTypeSize)
+		if column.dbTypeID == dbVarcharTypeID {
+			size = int(binary.LittleEndian.Uint16(b))
+			b = b[varcharPrefixSize:]
+		}
+
+		var value dbType
+		if !record.nulls.At(uint(i)) {
+			value = loadDBType(column.dbTypeID, append([]byte{}, b[:size]...))
+		}
+		record.dbTuple[column.name()] = value
+
+		b = b[size:]
+	}
+
+	return record
+}
@@ -0,0 +1,330 @@
+package data
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"hash/fnv"
+	"math"
+)
+
+// tableStatistics is the in-memory form of the SYS_STATISTICS rows of a table.
+type tableStatistics struct {
+	rows    int64
+	blocks  int64
+	columns map[string]columnStatistics
+}
+
+type columnStatistics struct {
+	nulls    int64
+	distinct int64
+	min      dbType
+	max      dbType
+}
+
+// TableStatistics holds the figures gathered by the last Analyze of a table.
+type TableStatistics struct {
+	RowCount   int64
+	BlockCount int64
+	Columns    []ColumnStatistics
+}
+
+/*
+ColumnStatistics describes the values of a column. MinValue and MaxValue are nil when the
+column only holds NULLs; for CHAR columns they keep at most maxStatisticsValueLength bytes.
+*/
+type ColumnStatistics struct {
+	ColumnName     string
+	NullFraction   float64
+	DistinctValues int64
+	MinValue       interface{}
+	MaxValue       interface{}
+}
+
+func (s tableStatistics) public(table dbTable) *TableStatistics {
+	statistics := &TableStatistics{RowCount: s.rows, BlockCount: s.blocks}
+
+	for _, column := range table.dbColumns {
+		c, ok := s.columns[column.name()]
+		if !ok {
+			continue
+		}
+
+		nullFraction := 0.0
+		if s.rows > 0 {
+			nullFraction = float64(c.nulls) / float64(s.rows)
+		}
+
+		statistics.Columns = append(statistics.Columns, ColumnStatistics{
+			ColumnName:     column.name(),
+			NullFraction:   nullFraction,
+			DistinctValues: c.distinct,
+			MinValue:       stdType(c.min),
+			MaxValue:       stdType(c.max),
+		})
+	}
+
+	return statistics
+}
+
+func (db *Database) Analyze(name string) error {
+	return db.AnalyzeContext(context.Background(), name)
+}
+
+/*
+AnalyzeContext scans the table and replaces its rows in SYS_STATISTICS with its current row
+count, block count and per column null fraction, distinct value estimate and min/max.
+*/
+func (db *Database) AnalyzeContext(ctx context.Context, name string) error {
+	table, err := db.table(name)
+	if err != nil {
+		return err
+	}
+
+	return db.atomically(func() error {
+		statistics, err := db.analyze(ctx, *table)
+		if err != nil {
+			return err
+		}
+
+		if err := db.saveStatistics(ctx, *table, statistics); err != nil {
+			return err
+		}
+
+		table.statistics = statistics
+		return nil
+	})
+}
+
+func (db *Database) analyze(ctx context.Context, table dbTable) (*tableStatistics, error) {
+	statistics := &tableStatistics{columns: map[string]columnStatistics{}}
+	sketches := map[string]*distinctSketch{}
+	for _, column := range table.dbColumns {
+		sketches[column.name()] = newDistinctSketch(distinctSketchSize)
+	}
+
+	blocks, err := db.scanTuples(ctx, table, nil, func(tuple dbTuple) (bool, error) {
+		statistics.rows++
+
+		for _, column := range table.dbColumns {
+			name := column.name()
+			c := statistics.columns[name]
+
+			value := tuple[name]
+			if value == nil {
+				c.nulls++
+				statistics.columns[name] = c
+				continue
+			}
+
+			sketches[name].add(value)
+
+			if c.min == nil {
+				c.min, c.max = value, value
+			} else if cmp, err := compareValues(stdType(value), stdType(c.min)); err != nil {
+				return false, err
+			} else if cmp < 0 {
+				c.min = value
+			} else if cmp, err := compareValues(stdType(value), stdType(c.max)); err != nil {
+				return false, err
+			} else if cmp > 0 {
+				c.max = value
+			}
+
+			statistics.columns[name] = c
+		}
+
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	statistics.blocks = blocks
+	for name, sketch := range sketches {
+		c := statistics.columns[name]
+		c.distinct = sketch.estimate()
+		statistics.columns[name] = c
+	}
+
+	return statistics, nil
+}
+
+func (db *Database) saveStatistics(ctx context.Context, table dbTable, statistics *tableStatistics) error {
+	if db.statisticsRecordBlock == nullBlockAddr {
+		if err := db.createSysTable(&db.statisticsRecordBlock, db.sysStatistics()); err != nil {
+			return err
+		}
+	}
+
+	sysStatistics := db.sysStatistics()
+	if err := db.delete(ctx, sysStatistics, dropCondition("SYS_STATISTICS", "TABLE_ID", int64(table.dbTableID)), nil); err != nil {
+		return err
+	}
+
+	for _, column := range table.dbColumns {
+		c := statistics.columns[column.name()]
+
+		values := map[string]dbType{
+			"TABLE_ID":       table.dbTableID,
+			"COLUMN_ID":      column.dbColumnID,
+			"ROW_COUNT":      dbInteger(statistics.rows),
+			"BLOCK_COUNT":    dbInteger(statistics.blocks),
+			"NULL_COUNT":     dbInteger(c.nulls),
+			"DISTINCT_COUNT": dbInteger(c.distinct),
+			"MIN_VALUE":      encodeStatisticsValue(c.min),
+			"MAX_VALUE":      encodeStatisticsValue(c.max),
+		}
+
+		if err := db.insert(ctx, sysStatistics, values); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadStatistics attaches the rows of SYS_STATISTICS to the loaded tables.
+func (db *Database) loadStatistics() error {
+	if db.statisticsRecordBlock == nullBlockAddr {
+		return nil
+	}
+
+	set, err := db.tableSet(db.sysStatistics())
+	if err != nil {
+		return err
+	}
+
+	for _, row := range set {
+		tableID := row["SYS_STATISTICS.TABLE_ID"].(dbInteger)
+
+		for i := range db.dbTables {
+			table := &db.dbTables[i]
+			if table.dbTableID != tableID {
+				continue
+			}
+
+			if table.statistics == nil {
+				table.statistics = &tableStatistics{
+					rows:    int64(row["SYS_STATISTICS.ROW_COUNT"].(dbInteger)),
+					blocks:  int64(row["SYS_STATISTICS.BLOCK_COUNT"].(dbInteger)),
+					columns: map[string]columnStatistics{},
+				}
+			}
+
+			columnID := row["SYS_STATISTICS.COLUMN_ID"].(dbInteger)
+			for _, column := range table.dbColumns {
+				if column.dbColumnID != columnID {
+					continue
+				}
+
+				table.statistics.columns[column.name()] = columnStatistics{
+					nulls:    int64(row["SYS_STATISTICS.NULL_COUNT"].(dbInteger)),
+					distinct: int64(row["SYS_STATISTICS.DISTINCT_COUNT"].(dbInteger)),
+					min:      decodeStatisticsValue(column, row["SYS_STATISTICS.MIN_VALUE"]),
+					max:      decodeStatisticsValue(column, row["SYS_STATISTICS.MAX_VALUE"]),
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// dropStatistics deletes the SYS_STATISTICS rows of a table.
+func (db *Database) dropStatistics(ctx context.Context, table dbTable) error {
+	if db.statisticsRecordBlock == nullBlockAddr {
+		return nil
+	}
+
+	return db.delete(ctx, db.sysStatistics(), dropCondition("SYS_STATISTICS", "TABLE_ID", int64(table.dbTableID)), nil)
+}
+
+// encodeStatisticsValue stores a value of any type in a MIN_VALUE or MAX_VALUE column.
+func encodeStatisticsValue(value dbType) dbType {
+	if value == nil {
+		return nil
+	}
+
+	b := make(dbChar, maxStatisticsValueLength)
+	copy(b, value.bytes())
+	return b
+}
+
+func decodeStatisticsValue(column dbColumn, value dbType) dbType {
+	b, ok := value.(dbChar)
+	if !ok {
+		return nil
+	}
+
+	if textType(column.dbTypeID) {
+		return dbChar(bytes.TrimRight(b, "\x00"))
+	}
+	return loadDBType(column.dbTypeID, b[:column.dbTypeSize])
+}
+
+/*
+distinctSketch estimates the number of distinct values it is given by keeping only the k
+smallest value hashes. While fewer than k distinct hashes were seen the count is exact.
+*/
+type distinctSketch struct {
+	k      int
+	hashes hashHeap
+	seen   map[uint64]bool
+}
+
+func newDistinctSketch(k int) *distinctSketch {
+	return &distinctSketch{k: k, seen: map[uint64]bool{}}
+}
+
+func (s *distinctSketch) add(value dbType) {
+	b := value.bytes()
+	if value.dbTypeID() == dbCharTypeID {
+		b = bytes.TrimRight(b, "\x00")
+	}
+
+	h := fnv.New64a()
+	h.Write(b)
+	hash := h.Sum64()
+
+	if s.seen[hash] {
+		return
+	}
+
+	if len(s.hashes) < s.k {
+		heap.Push(&s.hashes, hash)
+		s.seen[hash] = true
+	} else if hash < s.hashes[0] {
+		delete(s.seen, s.hashes[0])
+		s.hashes[0] = hash
+		heap.Fix(&s.hashes, 0)
+		s.seen[hash] = true
+	}
+}
+
+func (s *distinctSketch) estimate() int64 {
+	if len(s.hashes) < s.k {
+		return int64(len(s.hashes))
+	}
+
+	// The k smallest of n uniform hashes are spread over about k/n of the hash space.
+	return int64(float64(s.k-1) / (float64(s.hashes[0]) / math.MaxUint64))
+}
+
+// hashHeap is a max-heap of hashes.
+type hashHeap []uint64
+
+func (h hashHeap) Len() int           { return len(h) }
+func (h hashHeap) Less(i, j int) bool { return h[i] > h[j] }
+func (h hashHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *hashHeap) Push(x interface{}) {
+	*h = append(*h, x.(uint64))
+}
+
+func (h *hashHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,279 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/modest-sql/common"
+)
+
+type subqueryKind uint8
+
+const (
+	inSubquery subqueryKind = iota
+	existsSubquery
+	scalarSubquery
+)
+
+/*
+SubqueryExpression evaluates a select command as part of a condition. Subqueries that
+reference columns of the enclosing query are correlated and run once per evaluation with
+those columns bound to the current row; the rest run once per statement and are cached.
+*/
+type SubqueryExpression struct {
+	db      *Database
+	kind    subqueryKind
+	operand common.Expression
+	query   selectQuery
+	outer   []string
+	cached  bool
+	rows    dbSet
+	// ctx is the context of the statement evaluating the subquery, set by resetSubqueries.
+	ctx context.Context
+}
+
+// InSubquery creates the condition `operand IN (cmd)'. cmd must project a single column.
+func (db *Database) InSubquery(operand common.Expression, cmd *common.SelectTableCommand) (*SubqueryExpression, error) {
+	return db.newSubquery(inSubquery, operand, cmd)
+}
+
+// ExistsSubquery creates the condition `EXISTS (cmd)'.
+func (db *Database) ExistsSubquery(cmd *common.SelectTableCommand) (*SubqueryExpression, error) {
+	return db.newSubquery(existsSubquery, nil, cmd)
+}
+
+/*
+ScalarSubquery creates an expression evaluating to the only value produced by cmd, or NULL
+when cmd produces no rows. cmd must project a single column.
+*/
+func (db *Database) ScalarSubquery(cmd *common.SelectTableCommand) (*SubqueryExpression, error) {
+	return db.newSubquery(scalarSubquery, nil, cmd)
+}
+
+func (db *Database) newSubquery(kind subqueryKind, operand common.Expression, cmd *common.SelectTableCommand) (*SubqueryExpression, error) {
+	query, err := newSelectQuery(cmd, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.subquery(kind, operand, query)
+}
+
+func (db *Database) subquery(kind subqueryKind, operand common.Expression, query selectQuery) (*SubqueryExpression, error) {
+	switch kind {
+	case existsSubquery:
+		query.limit = 1
+	case scalarSubquery:
+		query.limit = 2
+	}
+
+	outer, err := db.outerColumns(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SubqueryExpression{db: db, kind: kind, operand: operand, query: query, outer: outer}, nil
+}
+
+// outerColumns returns the columns referenced by query that don't belong to any of its tables.
+func (db *Database) outerColumns(query selectQuery) (outer []string, err error) {
+	tables, err := db.queryTables(query)
+	if err != nil {
+		return nil, err
+	}
+
+	scope := []string{}
+	for _, table := range tables {
+		for _, column := range table.dbColumns {
+			scope = append(scope, column.name())
+		}
+	}
+
+	for _, expr := range query.expressions() {
+		for _, column := range referencedColumns(expr) {
+			if len(resolveColumn(column, scope)) == 0 {
+				outer = append(outer, column)
+			}
+		}
+	}
+
+	return outer, nil
+}
+
+func (e *SubqueryExpression) correlated() bool {
+	return len(e.outer) > 0
+}
+
+func (e *SubqueryExpression) Evaluate(symbols map[string]interface{}) interface{} {
+	var operand interface{}
+	if e.operand != nil {
+		operand = e.operand.Evaluate(symbols)
+		if err, ok := operand.(error); ok {
+			return err
+		}
+	}
+
+	rows, err := e.results(symbols)
+	if err != nil {
+		return err
+	}
+
+	switch e.kind {
+	case existsSubquery:
+		return len(rows) > 0
+	case scalarSubquery:
+		if len(rows) > 1 {
+			return errors.New("Scalar subquery returned more than one row")
+		}
+
+		for _, row := range rows {
+			return subqueryValue(row)
+		}
+		return nil
+	case inSubquery:
+		if operand == nil {
+			return nil
+		}
+
+		unknown := false
+		for _, row := range rows {
+			value := subqueryValue(row)
+			if value == nil {
+				unknown = true
+				continue
+			}
+
+			c, err := compareValues(operand, value)
+			if err != nil {
+				return err
+			}
+
+			if c == 0 {
+				return true
+			}
+		}
+
+		if unknown {
+			return nil
+		}
+		return false
+	}
+
+	return fmt.Errorf("Unknown subquery kind %d", e.kind)
+}
+
+func (e *SubqueryExpression) results(symbols map[string]interface{}) (dbSet, error) {
+	if e.cached {
+		return e.rows, nil
+	}
+
+	query := e.query
+	if e.correlated() {
+		bound := map[string]bool{}
+		for _, column := range e.outer {
+			bound[column] = true
+		}
+
+		query = query.rewrite(func(expr common.Expression) common.Expression {
+			return bindColumn(expr, bound, symbols)
+		})
+	}
+
+	plan, err := e.db.plan(query)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.kind != existsSubquery && len(plan.columns()) != 1 {
+		return nil, fmt.Errorf("Subquery must return a single column, got %d", len(plan.columns()))
+	}
+
+	ctx := e.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	rows := dbSet{}
+	err = plan.run(ctx, e.db, func(tuple dbTuple) (bool, error) {
+		rows = append(rows, tuple)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !e.correlated() {
+		e.rows, e.cached = rows, true
+	}
+
+	return rows, nil
+}
+
+func (e *SubqueryExpression) operands() []common.Expression {
+	if e.operand == nil {
+		return nil
+	}
+	return []common.Expression{e.operand}
+}
+
+func (e *SubqueryExpression) String() string {
+	switch e.kind {
+	case inSubquery:
+		return fmt.Sprintf("%s IN (%s)", expressionString(e.operand), e.query)
+	case existsSubquery:
+		return fmt.Sprintf("EXISTS (%s)", e.query)
+	}
+	return fmt.Sprintf("(%s)", e.query)
+}
+
+func subqueryValue(row dbTuple) interface{} {
+	for _, value := range row {
+		return stdType(value)
+	}
+	return nil
+}
+
+/*
+bindColumn replaces references to the bound columns by their value in symbols. Nested
+subqueries lose the bound columns from their outer references.
+*/
+func bindColumn(expr common.Expression, bound map[string]bool, symbols map[string]interface{}) common.Expression {
+	switch e := expr.(type) {
+	case *ColumnExpression:
+		if bound[e.name] {
+			return NewLiteralExpression(e.Evaluate(symbols))
+		}
+	case *SubqueryExpression:
+		nested := *e
+		nested.outer, nested.cached, nested.rows = nil, false, nil
+		for _, column := range e.outer {
+			if !bound[column] {
+				nested.outer = append(nested.outer, column)
+			}
+		}
+		nested.query = e.query.rewrite(func(expr common.Expression) common.Expression {
+			return bindColumn(expr, bound, symbols)
+		})
+		return &nested
+	}
+
+	return expr
+}
+
+/*
+resetSubqueries prepares the subqueries in exprs for a new statement running with ctx: their
+cached results are discarded, so they see current data, and they run with ctx from now on.
+*/
+func resetSubqueries(ctx context.Context, exprs ...common.Expression) {
+	for _, expr := range exprs {
+		if subquery, ok := expr.(*SubqueryExpression); ok {
+			subquery.cached, subquery.rows, subquery.ctx = false, nil, ctx
+			resetSubqueries(ctx, subquery.query.expressions()...)
+		}
+
+		if composite, ok := expr.(compositeExpression); ok {
+			resetSubqueries(ctx, composite.operands()...)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ChangeOperation is the kind of change described by a ChangeEvent.
+type ChangeOperation uint8
+
+const (
+	ChangeInsert ChangeOperation = iota
+	ChangeUpdate
+	ChangeDelete
+	ChangeCreateTable
+	ChangeDropTable
+	ChangeTruncateTable
+)
+
+var changeOperationNames = map[ChangeOperation]string{
+	ChangeInsert:        "INSERT",
+	ChangeUpdate:        "UPDATE",
+	ChangeDelete:        "DELETE",
+	ChangeCreateTable:   "CREATE TABLE",
+	ChangeDropTable:     "DROP TABLE",
+	ChangeTruncateTable: "TRUNCATE TABLE",
+}
+
+func (o ChangeOperation) String() string {
+	return changeOperationNames[o]
+}
+
+/*
+ChangeEvent describes a committed change of a table. Sequence numbers grow by one with every
+event and keep growing across LoadDatabase. Key holds the primary key columns of the row,
+and Old and New its values before and after the change; they are nil for table events.
+*/
+type ChangeEvent struct {
+	Sequence  int64
+	Table     string
+	Operation ChangeOperation
+	Key       map[string]interface{}
+	Old       map[string]interface{}
+	New       map[string]interface{}
+}
+
+// ErrSubscriptionOverflow ends a subscription whose buffer filled up because its events weren't read.
+var ErrSubscriptionOverflow = errors.New("Subscription buffer overflow")
+
+/*
+ErrChangesUnavailable is returned by SubscribeFrom when some of the changes to resume from are
+no longer kept. The history of changes lives in memory only, so this is always the case for
+changes committed before LoadDatabase. The subscriber must then read the tables again and
+subscribe from the current sequence.
+*/
+var ErrChangesUnavailable = errors.New("Changes are no longer available, resync from the tables")
+
+/*
+Subscription delivers the change events of some tables. Its buffer holds changeBufferSize
+events; when a write finds it full the subscription ends with ErrSubscriptionOverflow,
+and the subscriber may resume with SubscribeFrom and the sequence of the last event it read.
+*/
+type Subscription struct {
+	feed   *changeFeed
+	tables map[string]bool
+	events chan ChangeEvent
+	err    error
+}
+
+// Events returns the channel of events, closed when the subscription ends.
+func (s *Subscription) Events() <-chan ChangeEvent {
+	return s.events
+}
+
+// Err returns why the subscription ended, or nil if it is still active or was closed.
+func (s *Subscription) Err() error {
+	s.feed.mu.Lock()
+	defer s.feed.mu.Unlock()
+	return s.err
+}
+
+func (s *Subscription) Close() {
+	s.feed.mu.Lock()
+	defer s.feed.mu.Unlock()
+	s.feed.unsubscribe(s, nil)
+}
+
+func (s *Subscription) wants(event ChangeEvent) bool {
+	return len(s.tables) == 0 || s.tables[event.Table]
+}
+
+/*
+changeFeed delivers committed changes to subscriptions and keeps the last changeHistorySize
+events for those resuming from a sequence number. It is shared with the goroutines of the
+subscribers, so it has its own lock.
+*/
+type changeFeed struct {
+	mu            sync.Mutex
+	subscriptions map[*Subscription]bool
+	history       []ChangeEvent
+}
+
+func newChangeFeed() *changeFeed {
+	return &changeFeed{subscriptions: map[*Subscription]bool{}}
+}
+
+func (f *changeFeed) publish(events []ChangeEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.history = append(f.history, events...)
+	if n := len(f.history) - changeHistorySize; n > 0 {
+		f.history = append([]ChangeEvent{}, f.history[n:]...)
+	}
+
+	for s := range f.subscriptions {
+		for _, event := range events {
+			if !s.wants(event) {
+				continue
+			}
+
+			select {
+			case s.events <- event:
+			default:
+				f.unsubscribe(s, ErrSubscriptionOverflow)
+			}
+
+			if !f.subscriptions[s] {
+				break
+			}
+		}
+	}
+}
+
+func (f *changeFeed) unsubscribe(s *Subscription, err error) {
+	if !f.subscriptions[s] {
+		return
+	}
+
+	delete(f.subscriptions, s)
+	s.err = err
+	close(s.events)
+}
+
+// Subscribe delivers the changes committed from now on to the tables, or to all tables if none is given.
+func (db *Database) Subscribe(tables ...string) *Subscription {
+	s, _ := db.subscribe(db.changeSequence, tables)
+	return s
+}
+
+/*
+SubscribeFrom delivers the changes to the tables committed after the event numbered sequence,
+starting with those already committed. It returns ErrChangesUnavailable if some of them are
+too old to be kept or were committed before the database was loaded.
+*/
+func (db *Database) SubscribeFrom(sequence int64, tables ...string) (*Subscription, error) {
+	return db.subscribe(sequence, tables)
+}
+
+func (db *Database) subscribe(sequence int64, tables []string) (*Subscription, error) {
+	feed := db.changes
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+
+	if sequence > db.changeSequence {
+		return nil, fmt.Errorf("Sequence %d hasn't been reached in Database `%s'", sequence, db.name())
+	}
+
+	oldest := db.changeSequence + 1
+	if len(feed.history) > 0 {
+		oldest = feed.history[0].Sequence
+	}
+
+	if sequence+1 < oldest {
+		return nil, ErrChangesUnavailable
+	}
+
+	s := &Subscription{feed: feed, tables: map[string]bool{}}
+	for _, table := range tables {
+		s.tables[table] = true
+	}
+
+	backlog := []ChangeEvent{}
+	for _, event := range feed.history {
+		if event.Sequence > sequence && s.wants(event) {
+			backlog = append(backlog, event)
+		}
+	}
+
+	s.events = make(chan ChangeEvent, changeBufferSize+len(backlog))
+	for _, event := range backlog {
+		s.events <- event
+	}
+
+	feed.subscriptions[s] = true
+	return s, nil
+}
+
+/*
+recordChange numbers a change of the table name and keeps it until the operation making
+it commits. Changes of system tables aren't recorded.
+*/
+func (db *Database) recordChange(table dbTable, operation ChangeOperation, old dbTuple, new dbTuple) {
+	if id, ok := db.dbTableIDs[table.name()]; !ok || id != table.dbTableID {
+		return
+	}
+
+	db.changeSequence++
+	event := ChangeEvent{
+		Sequence:  db.changeSequence,
+		Table:     table.name(),
+		Operation: operation,
+		Old:       rowImage(old),
+		New:       rowImage(new),
+	}
+
+	row := new
+	if row == nil {
+		row = old
+	}
+
+	for _, column := range table.dbColumns {
+		if row == nil || !column.hasConstraint(dbPrimaryKeyConstraint) {
+			continue
+		}
+
+		if event.Key == nil {
+			event.Key = map[string]interface{}{}
+		}
+		event.Key[trimName(column.dbColumnName)] = stdType(row[column.name()])
+	}
+
+	db.pendingChanges = append(db.pendingChanges, event)
+}
+
+// publishChanges delivers the changes of an operation once it has committed.
+func (db *Database) publishChanges() {
+	if len(db.pendingChanges) > 0 {
+		db.changes.publish(db.pendingChanges)
+	}
+	db.pendingChanges = nil
+}
@@ -5,6 +5,12 @@ const (
 	dbSysColumnsID
 	dbDefaultNumericsID
 	dbDefaultCharsID
+	dbSysStatisticsID
+	dbSysViewsID
+	dbSysMaterializedViewsID
+	dbSysUniquesID
+	dbSysChecksID
+	dbSysSequencesID
 )
 
 const (
@@ -42,6 +48,58 @@ var sysDefaultCharsColumns = []dbColumn{
 	buildColumn(1, dbDefaultCharsID, dbCharTypeID, maxCharLength, "SYS_DEFAULT_CHARS", "VALUE"),
 }
 
+var sysStatisticsColumns = []dbColumn{
+	buildColumn(0, dbSysStatisticsID, dbIntegerTypeID, dbIntegerSize, "SYS_STATISTICS", "TABLE_ID"),
+	buildColumn(1, dbSysStatisticsID, dbIntegerTypeID, dbIntegerSize, "SYS_STATISTICS", "COLUMN_ID"),
+	buildColumn(2, dbSysStatisticsID, dbIntegerTypeID, dbIntegerSize, "SYS_STATISTICS", "ROW_COUNT"),
+	buildColumn(3, dbSysStatisticsID, dbIntegerTypeID, dbIntegerSize, "SYS_STATISTICS", "BLOCK_COUNT"),
+	buildColumn(4, dbSysStatisticsID, dbIntegerTypeID, dbIntegerSize, "SYS_STATISTICS", "NULL_COUNT"),
+	buildColumn(5, dbSysStatisticsID, dbIntegerTypeID, dbIntegerSize, "SYS_STATISTICS", "DISTINCT_COUNT"),
+	buildColumn(6, dbSysStatisticsID, dbCharTypeID, maxStatisticsValueLength, "SYS_STATISTICS", "MIN_VALUE"),
+	buildColumn(7, dbSysStatisticsID, dbCharTypeID, maxStatisticsValueLength, "SYS_STATISTICS", "MAX_VALUE"),
+}
+
+var sysViewsColumns = []dbColumn{
+	buildColumn(0, dbSysViewsID, dbIntegerTypeID, dbIntegerSize, "SYS_VIEWS", "VIEW_ID"),
+	buildColumn(1, dbSysViewsID, dbIntegerTypeID, dbIntegerSize, "SYS_VIEWS", "CHUNK"),
+	buildColumn(2, dbSysViewsID, dbCharTypeID, maxNameLength, "SYS_VIEWS", "VIEW_NAME"),
+	buildColumn(3, dbSysViewsID, dbCharTypeID, maxCharLength, "SYS_VIEWS", "DEFINITION"),
+}
+
+var sysMaterializedViewsColumns = []dbColumn{
+	buildColumn(0, dbSysMaterializedViewsID, dbIntegerTypeID, dbIntegerSize, "SYS_MATERIALIZED_VIEWS", "TABLE_ID"),
+	buildColumn(1, dbSysMaterializedViewsID, dbIntegerTypeID, dbIntegerSize, "SYS_MATERIALIZED_VIEWS", "CHUNK"),
+	buildColumn(2, dbSysMaterializedViewsID, dbCharTypeID, maxCharLength, "SYS_MATERIALIZED_VIEWS", "DEFINITION"),
+}
+
+var sysUniquesColumns = []dbColumn{
+	buildColumn(0, dbSysUniquesID, dbIntegerTypeID, dbIntegerSize, "SYS_UNIQUES", "CONSTRAINT_ID"),
+	buildColumn(1, dbSysUniquesID, dbIntegerTypeID, dbIntegerSize, "SYS_UNIQUES", "TABLE_ID"),
+	buildColumn(2, dbSysUniquesID, dbIntegerTypeID, dbIntegerSize, "SYS_UNIQUES", "COLUMN_ID"),
+	buildColumn(3, dbSysUniquesID, dbIntegerTypeID, dbIntegerSize, "SYS_UNIQUES", "COLUMN_ORDER"),
+	buildColumn(4, dbSysUniquesID, dbCharTypeID, maxNameLength, "SYS_UNIQUES", "CONSTRAINT_NAME"),
+}
+
+var sysChecksColumns = []dbColumn{
+	buildColumn(0, dbSysChecksID, dbIntegerTypeID, dbIntegerSize, "SYS_CHECKS", "CONSTRAINT_ID"),
+	buildColumn(1, dbSysChecksID, dbIntegerTypeID, dbIntegerSize, "SYS_CHECKS", "TABLE_ID"),
+	buildColumn(2, dbSysChecksID, dbIntegerTypeID, dbIntegerSize, "SYS_CHECKS", "COLUMN_ID"),
+	buildColumn(3, dbSysChecksID, dbIntegerTypeID, dbIntegerSize, "SYS_CHECKS", "CHUNK"),
+	buildColumn(4, dbSysChecksID, dbCharTypeID, maxNameLength, "SYS_CHECKS", "CONSTRAINT_NAME"),
+	buildColumn(5, dbSysChecksID, dbCharTypeID, maxCharLength, "SYS_CHECKS", "DEFINITION"),
+}
+
+var sysSequencesColumns = []dbColumn{
+	buildColumn(0, dbSysSequencesID, dbIntegerTypeID, dbIntegerSize, "SYS_SEQUENCES", "SEQUENCE_ID"),
+	buildColumn(1, dbSysSequencesID, dbIntegerTypeID, dbIntegerSize, "SYS_SEQUENCES", "START_VALUE"),
+	buildColumn(2, dbSysSequencesID, dbIntegerTypeID, dbIntegerSize, "SYS_SEQUENCES", "INCREMENT"),
+	buildColumn(3, dbSysSequencesID, dbIntegerTypeID, dbIntegerSize, "SYS_SEQUENCES", "MIN_VALUE"),
+	buildColumn(4, dbSysSequencesID, dbIntegerTypeID, dbIntegerSize, "SYS_SEQUENCES", "MAX_VALUE"),
+	buildColumn(5, dbSysSequencesID, dbBooleanTypeID, dbBooleanSize, "SYS_SEQUENCES", "CYCLE"),
+	buildColumn(6, dbSysSequencesID, dbIntegerTypeID, dbIntegerSize, "SYS_SEQUENCES", "RESERVED"),
+	buildColumn(7, dbSysSequencesID, dbCharTypeID, maxNameLength, "SYS_SEQUENCES", "SEQUENCE_NAME"),
+}
+
 func buildColumn(i dbInteger, sysTableID dbInteger, typeID dbTypeID, typeSize dbInteger, table string, name string) dbColumn {
 	return dbColumn{
 		dbTable:          dbTable{dbTableName: dbChar(table)},
@@ -93,3 +151,27 @@ func newDefaultNumericsSysTable() dbTable {
 func newDefaultCharsSysTable() dbTable {
 	return newDBSysTable(dbDefaultNumericsID, dbChar("SYS_DEFAULT_CHARS"), sysDefaultCharsColumns, firstDefaultCharsAddr)
 }
+
+func newStatisticsSysTable(firstRecordBlockAddr dbInteger) dbTable {
+	return newDBSysTable(dbSysStatisticsID, dbChar("SYS_STATISTICS"), sysStatisticsColumns, firstRecordBlockAddr)
+}
+
+func newViewsSysTable(firstRecordBlockAddr dbInteger) dbTable {
+	return newDBSysTable(dbSysViewsID, dbChar("SYS_VIEWS"), sysViewsColumns, firstRecordBlockAddr)
+}
+
+func newMaterializedViewsSysTable(firstRecordBlockAddr dbInteger) dbTable {
+	return newDBSysTable(dbSysMaterializedViewsID, dbChar("SYS_MATERIALIZED_VIEWS"), sysMaterializedViewsColumns, firstRecordBlockAddr)
+}
+
+func newUniquesSysTable(firstRecordBlockAddr dbInteger) dbTable {
+	return newDBSysTable(dbSysUniquesID, dbChar("SYS_UNIQUES"), sysUniquesColumns, firstRecordBlockAddr)
+}
+
+func newChecksSysTable(firstRecordBlockAddr dbInteger) dbTable {
+	return newDBSysTable(dbSysChecksID, dbChar("SYS_CHECKS"), sysChecksColumns, firstRecordBlockAddr)
+}
+
+func newSequencesSysTable(firstRecordBlockAddr dbInteger) dbTable {
+	return newDBSysTable(dbSysSequencesID, dbChar("SYS_SEQUENCES"), sysSequencesColumns, firstRecordBlockAddr)
+}
@@ -13,6 +13,10 @@ type dbTable struct {
 	dbColumnIDs          map[string]dbInteger
 	dbColumns            []dbColumn
 	firstRecordBlockAddr dbInteger
+	statistics           *tableStatistics
+	uniques              []dbUnique
+	checks               []dbCheck
+	sourceName           string
 }
 
 func newDBTable(dbTableID dbInteger, dbTableName dbChar, dbColumns []dbColumn, firstRecordBlockAddr dbInteger) dbTable {
@@ -36,6 +40,33 @@ func (t dbTable) name() string {
 	return trimName(t.dbTableName)
 }
 
+/*
+withAlias returns a copy of the table whose columns are qualified with alias instead of
+the table name. The copy reads the same record blocks, so scanning it yields the table's
+tuples keyed by `ALIAS.COLUMN'.
+*/
+func (t dbTable) withAlias(alias string) dbTable {
+	if alias == "" || alias == t.name() {
+		return t
+	}
+
+	aliased := newDBTable(t.dbTableID, dbChar(alias), []dbColumn{}, t.firstRecordBlockAddr)
+	aliased.sourceName = t.name()
+	aliased.statistics = t.statistics
+	for _, column := range t.dbColumns {
+		aliased.addColumn(column)
+	}
+	return aliased
+}
+
+// sourceTableName returns the name of the stored table, which differs from name for aliases.
+func (t dbTable) sourceTableName() string {
+	if t.sourceName != "" {
+		return t.sourceName
+	}
+	return t.name()
+}
+
 func (t dbTable) column(name string) (*dbColumn, error) {
 	dbColumnID, ok := t.dbColumnIDs[qualifiedIdentifier(t, name)]
 	if !ok {
@@ -121,7 +152,22 @@ func (t dbTable) buildDBRecord(values map[string]dbType) (record dbRecord, err e
 	return record, nil
 }
 
+/*
+recordSize returns the size of a record in a fixed size record block. For slotted record
+blocks it is the size of a row whose VARCHAR values all take their maximum length.
+*/
 func (t dbTable) recordSize() (size int) {
+	if t.slotted() {
+		size += bitmapSize(len(t.dbColumns))
+		for _, column := range t.dbColumns {
+			size += int(column.dbTypeSize)
+			if column.dbTypeID == dbVarcharTypeID {
+				size += varcharPrefixSize
+			}
+		}
+		return size
+	}
+
 	size += freeFlagSize
 	size += bitmapSize(len(t.dbColumns)) //record's null bitmap size
 
@@ -132,10 +178,23 @@ func (t dbTable) recordSize() (size int) {
 }
 
 func (t dbTable) recordsPerBlock(blockSize int64) int {
+	if t.slotted() {
+		// Rows are usually shorter than their maximum, so at least one is counted.
+		if n := (int(blockSize) - slottedHeaderSize) / (slotSize + t.recordSize()); n > 1 {
+			return n
+		}
+		return 1
+	}
+
 	return (int(blockSize) - 8) / t.recordSize()
 }
 
 func (t dbTable) newDBRecordBlock(blockSize int64) (rb dbRecordBlock, err error) {
+	rb.size = int(blockSize)
+	if t.slotted() {
+		return rb, t.checkRecordFits(t.newDBRecord(), blockSize)
+	}
+
 	recordsPerBlock := t.recordsPerBlock(blockSize)
 	if recordsPerBlock == 0 {
 		return rb, errors.New("Record does not fit in record block")
@@ -149,6 +208,10 @@ func (t dbTable) newDBRecordBlock(blockSize int64) (rb dbRecordBlock, err error)
 }
 
 func (t dbTable) recordBlockBytes(recordBlock dbRecordBlock) (b []byte) {
+	if t.slotted() {
+		return t.slottedRecordBlockBytes(recordBlock)
+	}
+
 	b = make([]byte, binary.Size(int64(0)))
 	binary.LittleEndian.PutUint64(b, uint64(recordBlock.nextRecordBlock))
 
@@ -172,8 +235,12 @@ func (t dbTable) recordBlockBytes(recordBlock dbRecordBlock) (b []byte) {
 }
 
 func (t dbTable) loadRecordBlockBytes(b []byte) dbRecordBlock {
+	if t.slotted() {
+		return t.loadSlottedRecordBlockBytes(b)
+	}
+
 	recordSize := t.recordSize()
-	rb := dbRecordBlock{nextRecordBlock: int64(binary.LittleEndian.Uint64(b))}
+	rb := dbRecordBlock{nextRecordBlock: int64(binary.LittleEndian.Uint64(b)), size: len(b)}
 
 	for rs := b[recordsOffset:]; len(rs) >= recordSize; rs = rs[recordSize:] {
 		record := dbRecord{
@@ -201,3 +268,11 @@ func (t dbTable) loadRecordBlockBytes(b []byte) dbRecordBlock {
 
 	return rb
 }
+
+// insertRecord stores record in a free slot of rb, reporting false if rb has no room for it.
+func (t dbTable) insertRecord(rb *dbRecordBlock, record dbRecord) bool {
+	if t.slotted() {
+		return t.insertSlottedRecord(rb, record)
+	}
+	return rb.insertRecord(record)
+}
@@ -0,0 +1,129 @@
+package data
+
+import (
+	"context"
+	"fmt"
+)
+
+// TriggerEvent tells when a trigger runs: before or after rows are inserted, updated or deleted.
+type TriggerEvent uint8
+
+const (
+	BeforeInsert TriggerEvent = iota
+	AfterInsert
+	BeforeUpdate
+	AfterUpdate
+	BeforeDelete
+	AfterDelete
+)
+
+var triggerEventNames = map[TriggerEvent]string{
+	BeforeInsert: "BEFORE INSERT",
+	AfterInsert:  "AFTER INSERT",
+	BeforeUpdate: "BEFORE UPDATE",
+	AfterUpdate:  "AFTER UPDATE",
+	BeforeDelete: "BEFORE DELETE",
+	AfterDelete:  "AFTER DELETE",
+}
+
+func (e TriggerEvent) String() string {
+	return triggerEventNames[e]
+}
+
+/*
+TriggerFunc is called once per row with its values by column name. old is nil on insert and
+new is nil on delete. BEFORE INSERT and BEFORE UPDATE triggers may change new to change the
+row that is written. Returning an error rolls the whole operation back.
+*/
+type TriggerFunc func(old map[string]interface{}, new map[string]interface{}) error
+
+type dbTrigger struct {
+	event TriggerEvent
+	fn    TriggerFunc
+}
+
+/*
+RegisterTrigger runs fn on every row of the table name changed by event. Triggers of the same
+event run in the order they were registered. They live in memory only, so they must be
+registered again after LoadDatabase, and they are forgotten when the table is dropped.
+A trigger may write other tables; those writes are part of the operation that fired it.
+BEFORE UPDATE and BEFORE DELETE triggers can't write their own table. AFTER UPDATE and
+AFTER DELETE triggers run once every row has been changed, so the rows they write aren't
+changed again by the same statement.
+*/
+func (db *Database) RegisterTrigger(name string, event TriggerEvent, fn TriggerFunc) error {
+	if _, err := db.table(name); err != nil {
+		return err
+	}
+
+	if _, ok := triggerEventNames[event]; !ok {
+		return fmt.Errorf("Invalid trigger event %d", event)
+	}
+
+	if fn == nil {
+		return fmt.Errorf("%s trigger of Table `%s' has no function", event, name)
+	}
+
+	db.dbTriggers[name] = append(db.dbTriggers[name], dbTrigger{event: event, fn: fn})
+	return nil
+}
+
+func (db *Database) hasTriggers(table dbTable, event TriggerEvent) bool {
+	for _, trigger := range db.dbTriggers[table.name()] {
+		if trigger.event == event {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+fireTriggers runs the triggers of table for event with the row images old and new. For
+BEFORE INSERT and BEFORE UPDATE it returns the values of the row to write instead of new,
+or nil if the table has no such triggers.
+*/
+func (db *Database) fireTriggers(table dbTable, event TriggerEvent, old dbTuple, new dbTuple) (map[string]dbType, error) {
+	if !db.hasTriggers(table, event) {
+		return nil, nil
+	}
+
+	oldImage, newImage := rowImage(old), rowImage(new)
+
+	// The caller writes back the block holding the row once the triggers return.
+	if event == BeforeUpdate || event == BeforeDelete {
+		db.heldTables[table.name()] = event
+		defer delete(db.heldTables, table.name())
+	}
+
+	for _, trigger := range db.dbTriggers[table.name()] {
+		if trigger.event != event {
+			continue
+		}
+
+		if err := trigger.fn(oldImage, newImage); err != nil {
+			return nil, fmt.Errorf("%s trigger of Table `%s' failed: %v", event, table.name(), err)
+		}
+	}
+
+	if newImage == nil || (event != BeforeInsert && event != BeforeUpdate) {
+		return nil, nil
+	}
+	return convertValuesMap(table, newImage)
+}
+
+// afterTriggers runs the AFTER triggers of the change of a row of table.
+func (db *Database) afterTriggers(ctx context.Context, table dbTable, old dbTuple, new dbTuple) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	event := AfterUpdate
+	if old == nil {
+		event = AfterInsert
+	} else if new == nil {
+		event = AfterDelete
+	}
+
+	_, err := db.fireTriggers(table, event, old, new)
+	return err
+}
@@ -11,3 +11,19 @@ func (t dbTuple) stdMap() map[string]interface{} {
 
 	return values
 }
+
+// withRelation returns the tuple with the columns qualified with from qualified with to instead.
+func (t dbTuple) withRelation(from string, to string) dbTuple {
+	if from == to {
+		return t
+	}
+
+	renamed := dbTuple{}
+	for key, value := range t {
+		if tableName, columnName := splitIdentifier(key); tableName == from {
+			key = concatTable(to, columnName)
+		}
+		renamed[key] = value
+	}
+	return renamed
+}
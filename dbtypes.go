@@ -3,6 +3,7 @@ package data
 import (
 	"encoding/binary"
 	"fmt"
+	"math"
 	"reflect"
 
 	"github.com/modest-sql/common"
@@ -16,6 +17,7 @@ const (
 	dbBooleanTypeID
 	dbDateTimeTypeID
 	dbCharTypeID
+	dbVarcharTypeID
 )
 
 const (
@@ -59,7 +61,7 @@ func (dt dbFloat) dbTypeSize() int {
 
 func (dt dbFloat) bytes() []byte {
 	b := make([]byte, dbFloatSize)
-	binary.LittleEndian.PutUint64(b, uint64(dt))
+	binary.LittleEndian.PutUint64(b, math.Float64bits(float64(dt)))
 	return b
 }
 
@@ -125,7 +127,7 @@ func loadDBType(dbTypeID dbTypeID, b []byte) dbType {
 	case dbIntegerTypeID:
 		return dbInteger(binary.LittleEndian.Uint64(b))
 	case dbFloatTypeID:
-		return dbFloat(binary.LittleEndian.Uint64(b))
+		return dbFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)))
 	case dbDateTimeTypeID:
 		return dbDateTime(binary.LittleEndian.Uint64(b))
 	case dbBooleanTypeID:
@@ -133,7 +135,7 @@ func loadDBType(dbTypeID dbTypeID, b []byte) dbType {
 			return dbBoolean(true)
 		}
 		return dbBoolean(false)
-	case dbCharTypeID:
+	case dbCharTypeID, dbVarcharTypeID:
 		return dbChar(b)
 	}
 
@@ -157,6 +159,10 @@ func castDBType(definition common.TableColumnDefiner) dbType {
 	case bool:
 		return dbBoolean(v)
 	case string:
+		if _, ok := definition.(VarcharTableColumn); ok {
+			return dbChar(v)
+		}
+
 		size := definition.(common.CharTableColumn).Size()
 		tmp := make(dbChar, size)
 		copy(tmp, v)
@@ -202,15 +208,19 @@ func convertValuesMap(table dbTable, values map[string]interface{}) (map[string]
 			}
 			dbValue = dbBoolean(v)
 		case string:
-			if column.dbTypeID != dbCharTypeID {
-				return nil, fmt.Errorf("Column `%s' is not of type CHAR", column.name())
+			if !textType(column.dbTypeID) {
+				return nil, fmt.Errorf("Column `%s' is not of type CHAR or VARCHAR", column.name())
 			}
 
 			if len(v) > int(column.dbTypeSize) {
 				return nil, fmt.Errorf("Column `%s' length can't be greater than %d bytes", column.name(), column.dbTypeSize)
 			}
 
-			dbValue = newChar(column.dbTypeSize, v)
+			if column.dbTypeID == dbVarcharTypeID {
+				dbValue = dbChar(v)
+			} else {
+				dbValue = newChar(column.dbTypeSize, v)
+			}
 		default:
 			return nil, fmt.Errorf("Invalid %v type on column `%s'", reflect.TypeOf(v), column.name())
 		}
@@ -237,3 +247,22 @@ func stdType(value dbType) interface{} {
 
 	return nil
 }
+
+func castStdType(value interface{}) (dbType, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case int64:
+		return dbInteger(v), nil
+	case float64:
+		return dbFloat(v), nil
+	case bool:
+		return dbBoolean(v), nil
+	case string:
+		return dbChar(v), nil
+	case error:
+		return nil, v
+	}
+
+	return nil, fmt.Errorf("Invalid %v value", reflect.TypeOf(value))
+}
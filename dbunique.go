@@ -0,0 +1,280 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+dbUnique is a UNIQUE constraint over one or more columns of a table, stored in SYS_UNIQUES
+with one row per column. As in standard SQL, rows with a NULL in any of the columns never
+conflict. Columns that are unique by themselves also carry dbUniqueConstraint.
+*/
+type dbUnique struct {
+	dbConstraintID dbInteger
+	name           string
+	columns        []string
+}
+
+// UniqueConstraint describes a UNIQUE constraint of a table.
+type UniqueConstraint struct {
+	ConstraintName string
+	ColumnNames    []string
+}
+
+func (db *Database) AddUniqueConstraint(tableName string, constraintName string, columns ...string) error {
+	return db.AddUniqueConstraintContext(context.Background(), tableName, constraintName, columns...)
+}
+
+/*
+AddUniqueConstraintContext makes the combination of columns unique in the table. It fails if
+the rows already in the table hold duplicates.
+*/
+func (db *Database) AddUniqueConstraintContext(ctx context.Context, tableName string, constraintName string, columns ...string) error {
+	return db.atomically(func() error {
+		return db.addUnique(ctx, tableName, constraintName, columns)
+	})
+}
+
+func (db *Database) addUnique(ctx context.Context, tableName string, constraintName string, columnNames []string) error {
+	table, err := db.table(tableName)
+	if err != nil {
+		return err
+	}
+
+	if len(columnNames) == 0 {
+		return fmt.Errorf("UNIQUE constraint `%s' has no columns", constraintName)
+	}
+
+	if len(constraintName) > maxNameLength {
+		return fmt.Errorf("Constraint name `%s' can't be longer than %d bytes", constraintName, maxNameLength)
+	}
+
+	if db.constraintExists(constraintName) {
+		return fmt.Errorf("Duplicate constraint `%s' in Database `%s'", constraintName, db.name())
+	}
+
+	unique := dbUnique{dbConstraintID: db.nextUniqueID(), name: constraintName}
+	columns := []*dbColumn{}
+	for _, name := range columnNames {
+		column, err := table.column(name)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range unique.columns {
+			if c == column.name() {
+				return fmt.Errorf("Column `%s' appears more than once in constraint `%s'", column.name(), constraintName)
+			}
+		}
+
+		unique.columns = append(unique.columns, column.name())
+		columns = append(columns, column)
+	}
+
+	if db.uniquesRecordBlock == nullBlockAddr {
+		if err := db.createSysTable(&db.uniquesRecordBlock, db.sysUniques()); err != nil {
+			return err
+		}
+	}
+
+	uniqueName := make(dbChar, maxNameLength)
+	copy(uniqueName, constraintName)
+
+	for order, column := range columns {
+		values := map[string]dbType{
+			"CONSTRAINT_ID":   unique.dbConstraintID,
+			"TABLE_ID":        table.dbTableID,
+			"COLUMN_ID":       column.dbColumnID,
+			"COLUMN_ORDER":    dbInteger(order),
+			"CONSTRAINT_NAME": uniqueName,
+		}
+
+		if err := db.insert(ctx, db.sysUniques(), values); err != nil {
+			return err
+		}
+	}
+
+	if len(columns) == 1 && !columns[0].hasConstraint(dbUniqueConstraint) {
+		// The columns are copied so that a rollback restores the journaled ones.
+		table.dbColumns = append([]dbColumn{}, table.dbColumns...)
+
+		column, _ := table.column(columnNames[0])
+		column.addConstraint(dbUniqueConstraint)
+		if err := db.writeColumnConstraints(ctx, *column); err != nil {
+			return err
+		}
+	}
+
+	table.uniques = append(table.uniques[:len(table.uniques):len(table.uniques)], unique)
+
+	// Rows already in the table must satisfy the new constraint.
+	set, err := db.tableSet(*table)
+	if err != nil {
+		return err
+	}
+	return db.checkUniques(ctx, *table, set)
+}
+
+// writeColumnConstraints stores the constraints of column in its SYS_COLUMNS row.
+func (db *Database) writeColumnConstraints(ctx context.Context, column dbColumn) error {
+	return db.writeColumnValue(ctx, column, "COLUMN_CONSTRAINTS", dbInteger(column.dbConstraints))
+}
+
+// writeColumnValue stores value in the field of the SYS_COLUMNS row of column.
+func (db *Database) writeColumnValue(ctx context.Context, column dbColumn, field string, value dbType) error {
+	sysColumns := db.sysColumns()
+	sysColumn, err := sysColumns.column(field)
+	if err != nil {
+		return err
+	}
+
+	return db.scanRecordBlocks(ctx, sysColumns, func(addr int64, rb dbRecordBlock) (bool, error) {
+		for i := range rb.dbRecords {
+			if rb.dbRecords[i].isFree() || rb.dbRecords[i].dbTuple["SYS_COLUMNS.COLUMN_ID"] != column.dbColumnID {
+				continue
+			}
+
+			rb.dbRecords[i].insertColumnValue(value, *sysColumn)
+			return false, db.writeAt(sysColumns.recordBlockBytes(rb), addr)
+		}
+		return true, nil
+	})
+}
+
+// key returns the values of the constraint's columns in tuple, or false if any is NULL.
+func (u dbUnique) key(tuple dbTuple) (string, bool) {
+	values := dbTuple{}
+	for _, column := range u.columns {
+		value := tuple[column]
+		if value == nil {
+			return "", false
+		}
+		values[column] = value
+	}
+	return tupleKey(values), true
+}
+
+/*
+checkUniques fails if the stored rows of table hold any of the unique keys of tuples more
+than once. It is called once per statement, after all of tuples are written, so each of
+them finds at least itself; a single scan of the table checks every constraint.
+*/
+func (db *Database) checkUniques(ctx context.Context, table dbTable, tuples []dbTuple) error {
+	// counts[i] maps the keys of tuples under table.uniques[i] to the stored rows holding them.
+	counts := make([]map[string]int, len(table.uniques))
+	wanted := false
+	for i, unique := range table.uniques {
+		counts[i] = map[string]int{}
+		for _, tuple := range tuples {
+			if key, ok := unique.key(tuple); ok {
+				counts[i][key] = 0
+			}
+		}
+		wanted = wanted || len(counts[i]) > 0
+	}
+
+	if !wanted {
+		return nil
+	}
+
+	return db.scanTable(ctx, table, func(tuple dbTuple) (bool, error) {
+		for i, unique := range table.uniques {
+			key, ok := unique.key(tuple)
+			if n, wanted := counts[i][key]; ok && wanted {
+				if n > 0 {
+					return false, fmt.Errorf("Duplicate key (%s) violates UNIQUE constraint `%s' of Table `%s'", unique.keyString(tuple), unique.name, table.name())
+				}
+				counts[i][key] = n + 1
+			}
+		}
+		return true, nil
+	})
+}
+
+func (u dbUnique) keyString(tuple dbTuple) string {
+	values := []string{}
+	for _, column := range u.columns {
+		values = append(values, fmt.Sprint(stdType(tuple[column])))
+	}
+	return strings.Join(values, ", ")
+}
+
+func (u dbUnique) public() UniqueConstraint {
+	return UniqueConstraint{ConstraintName: u.name, ColumnNames: append([]string{}, u.columns...)}
+}
+
+func (db Database) sysUniques() dbTable {
+	return newUniquesSysTable(dbInteger(db.uniquesRecordBlock))
+}
+
+func (db Database) nextUniqueID() dbInteger {
+	id := dbInteger(0)
+	for _, table := range db.dbTables {
+		for _, unique := range table.uniques {
+			if unique.dbConstraintID > id {
+				id = unique.dbConstraintID
+			}
+		}
+	}
+	return id + 1
+}
+
+// loadUniques attaches the constraints stored in SYS_UNIQUES to the loaded tables.
+func (db *Database) loadUniques() error {
+	if db.uniquesRecordBlock == nullBlockAddr {
+		return nil
+	}
+
+	set, err := db.tableSet(db.sysUniques())
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(set, func(i, j int) bool {
+		a, b := set[i], set[j]
+		if a["SYS_UNIQUES.CONSTRAINT_ID"].(dbInteger) != b["SYS_UNIQUES.CONSTRAINT_ID"].(dbInteger) {
+			return a["SYS_UNIQUES.CONSTRAINT_ID"].(dbInteger) < b["SYS_UNIQUES.CONSTRAINT_ID"].(dbInteger)
+		}
+		return a["SYS_UNIQUES.COLUMN_ORDER"].(dbInteger) < b["SYS_UNIQUES.COLUMN_ORDER"].(dbInteger)
+	})
+
+	for _, row := range set {
+		tableID := row["SYS_UNIQUES.TABLE_ID"].(dbInteger)
+		constraintID := row["SYS_UNIQUES.CONSTRAINT_ID"].(dbInteger)
+
+		for i := range db.dbTables {
+			table := &db.dbTables[i]
+			if table.dbTableID != tableID {
+				continue
+			}
+
+			if n := len(table.uniques); n == 0 || table.uniques[n-1].dbConstraintID != constraintID {
+				table.uniques = append(table.uniques, dbUnique{
+					dbConstraintID: constraintID,
+					name:           trimName(row["SYS_UNIQUES.CONSTRAINT_NAME"].(dbChar)),
+				})
+			}
+
+			unique := &table.uniques[len(table.uniques)-1]
+			for _, column := range table.dbColumns {
+				if column.dbColumnID == row["SYS_UNIQUES.COLUMN_ID"].(dbInteger) {
+					unique.columns = append(unique.columns, column.name())
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// dropUniques deletes the SYS_UNIQUES rows of a table.
+func (db *Database) dropUniques(ctx context.Context, table dbTable) error {
+	if db.uniquesRecordBlock == nullBlockAddr {
+		return nil
+	}
+
+	return db.delete(ctx, db.sysUniques(), dropCondition("SYS_UNIQUES", "TABLE_ID", int64(table.dbTableID)), nil)
+}
@@ -0,0 +1,22 @@
+package data
+
+import "github.com/modest-sql/common"
+
+/*
+VarcharTableColumn defines a VARCHAR column holding text of up to Size bytes. Unlike CHAR,
+values aren't padded to the declared size: tables with VARCHAR columns are stored in slotted
+record blocks where each row only takes the bytes it uses. A default value can't be longer
+than maxCharLength bytes.
+*/
+type VarcharTableColumn struct {
+	common.CharTableColumn
+}
+
+func NewVarcharTableColumn(name string, defaultValue interface{}, nullable bool, autoincrementable bool, primaryKey bool, foreignKey bool, size uint32) VarcharTableColumn {
+	return VarcharTableColumn{common.NewCharTableColumn(name, defaultValue, nullable, autoincrementable, primaryKey, foreignKey, size)}
+}
+
+// textType tells if values of the type are text, stored as dbChar.
+func textType(id dbTypeID) bool {
+	return id == dbCharTypeID || id == dbVarcharTypeID
+}
@@ -0,0 +1,372 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/modest-sql/common"
+)
+
+// dbView is a named select query stored in SYS_VIEWS and expanded wherever it is referenced.
+type dbView struct {
+	dbViewID   dbInteger
+	name       string
+	definition string
+}
+
+// View describes a view and the columns it produces.
+type View struct {
+	ViewName    string
+	ViewColumns []TableColumn
+	Definition  string
+}
+
+/*
+CreateViewCommand stores a select command under a name, like CREATE VIEW name AS cmd or
+CREATE MATERIALIZED VIEW name AS cmd.
+*/
+type CreateViewCommand struct {
+	name         string
+	cmd          *common.SelectTableCommand
+	materialized bool
+}
+
+func NewCreateViewCommand(name string, cmd *common.SelectTableCommand) *CreateViewCommand {
+	return &CreateViewCommand{name: name, cmd: cmd}
+}
+
+func NewCreateMaterializedViewCommand(name string, cmd *common.SelectTableCommand) *CreateViewCommand {
+	return &CreateViewCommand{name: name, cmd: cmd, materialized: true}
+}
+
+func (c *CreateViewCommand) ViewName() string {
+	return c.name
+}
+
+func (c *CreateViewCommand) SelectCommand() *common.SelectTableCommand {
+	return c.cmd
+}
+
+func (c *CreateViewCommand) Materialized() bool {
+	return c.materialized
+}
+
+// DropViewCommand deletes a view, like DROP VIEW name.
+type DropViewCommand struct {
+	name string
+}
+
+func NewDropViewCommand(name string) *DropViewCommand {
+	return &DropViewCommand{name: name}
+}
+
+func (c *DropViewCommand) ViewName() string {
+	return c.name
+}
+
+func (db *Database) CreateView(name string, cmd *common.SelectTableCommand, options ...SelectOption) error {
+	return db.CreateViewContext(context.Background(), name, cmd, options...)
+}
+
+/*
+CreateViewContext stores cmd as the view name. The view can then be selected from and
+joined like a table; its query runs every time it is referenced. Only the expressions of
+this package, like ColumnExpression, LiteralExpression and ComparisonExpression, can be
+stored in a view; those of package common can't be inspected and are rejected.
+*/
+func (db *Database) CreateViewContext(ctx context.Context, name string, cmd *common.SelectTableCommand, options ...SelectOption) error {
+	query, err := newSelectQuery(cmd, options)
+	if err != nil {
+		return err
+	}
+
+	return db.atomically(func() error {
+		return db.createView(ctx, name, query)
+	})
+}
+
+func (db *Database) createView(ctx context.Context, name string, query selectQuery) error {
+	if _, err := db.table(name); err == nil {
+		return fmt.Errorf("Table `%s' already exists in Database `%s'", name, db.name())
+	}
+
+	if _, ok := db.dbViews[name]; ok {
+		return fmt.Errorf("Duplicate view `%s' in Database `%s'", name, db.name())
+	}
+
+	definition, err := marshalQuery(query)
+	if err != nil {
+		return err
+	}
+
+	view := &dbView{dbViewID: db.nextViewID(), name: name, definition: definition}
+	if _, err := db.viewTable(view, ""); err != nil {
+		return err
+	}
+
+	if db.viewsRecordBlock == nullBlockAddr {
+		if err := db.createSysTable(&db.viewsRecordBlock, db.sysViews()); err != nil {
+			return err
+		}
+	}
+
+	viewName := make(dbChar, maxNameLength)
+	copy(viewName, name)
+
+	for chunk, part := range definitionChunks(definition) {
+		values := map[string]dbType{
+			"VIEW_ID":    view.dbViewID,
+			"CHUNK":      dbInteger(chunk),
+			"VIEW_NAME":  viewName,
+			"DEFINITION": part,
+		}
+
+		if err := db.insert(ctx, db.sysViews(), values); err != nil {
+			return err
+		}
+	}
+
+	db.dbViews[name] = view
+	return nil
+}
+
+func (db *Database) DropView(name string) error {
+	return db.DropViewContext(context.Background(), name)
+}
+
+func (db *Database) DropViewContext(ctx context.Context, name string) error {
+	return db.atomically(func() error {
+		return db.dropView(ctx, name)
+	})
+}
+
+func (db *Database) dropView(ctx context.Context, name string) error {
+	if _, ok := db.dbMaterializedViews[name]; ok {
+		return db.drop(ctx, name)
+	}
+
+	view, ok := db.dbViews[name]
+	if !ok {
+		return fmt.Errorf("View `%s' does not exist in Database `%s'", name, db.name())
+	}
+
+	if err := db.checkNoDependentViews(name); err != nil {
+		return err
+	}
+
+	if err := db.delete(ctx, db.sysViews(), dropCondition("SYS_VIEWS", "VIEW_ID", int64(view.dbViewID)), nil); err != nil {
+		return err
+	}
+
+	delete(db.dbViews, name)
+	return nil
+}
+
+func (db Database) AllViews() []*View {
+	names := []string{}
+	for name := range db.dbViews {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	views := []*View{}
+	for _, name := range names {
+		view := db.dbViews[name]
+
+		table, err := db.viewTable(view, "")
+		if err != nil {
+			continue
+		}
+
+		var columns []TableColumn
+		for _, column := range table.dbColumns {
+			columns = append(columns, dbColumnToTableColumn(column))
+		}
+
+		definition := view.definition
+		if query, err := db.unmarshalQuery(view.definition); err == nil {
+			definition = query.String()
+		}
+
+		views = append(views, &View{ViewName: name, ViewColumns: columns, Definition: definition})
+	}
+
+	return views
+}
+
+// definitionChunks splits a stored definition into values for consecutive CHAR rows.
+func definitionChunks(definition string) (chunks []dbChar) {
+	for start := 0; start < len(definition); start += maxCharLength {
+		chunk := make(dbChar, maxCharLength)
+		copy(chunk, definition[start:])
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+func (db Database) sysViews() dbTable {
+	return newViewsSysTable(dbInteger(db.viewsRecordBlock))
+}
+
+func (db Database) nextViewID() dbInteger {
+	id := dbInteger(0)
+	for _, view := range db.dbViews {
+		if view.dbViewID > id {
+			id = view.dbViewID
+		}
+	}
+	return id + 1
+}
+
+// loadViews reads the view definitions stored in SYS_VIEWS.
+func (db *Database) loadViews() error {
+	if db.viewsRecordBlock == nullBlockAddr {
+		return nil
+	}
+
+	set, err := db.tableSet(db.sysViews())
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(set, func(i, j int) bool {
+		return set[i]["SYS_VIEWS.CHUNK"].(dbInteger) < set[j]["SYS_VIEWS.CHUNK"].(dbInteger)
+	})
+
+	for _, row := range set {
+		name := trimName(row["SYS_VIEWS.VIEW_NAME"].(dbChar))
+
+		view, ok := db.dbViews[name]
+		if !ok {
+			view = &dbView{dbViewID: row["SYS_VIEWS.VIEW_ID"].(dbInteger), name: name}
+			db.dbViews[name] = view
+		}
+		view.definition += trimName(row["SYS_VIEWS.DEFINITION"].(dbChar))
+	}
+
+	return nil
+}
+
+/*
+viewTable plans the query of view and describes its result as a table whose columns are
+qualified with alias, or the view name when alias is empty. The table has no record
+blocks; its rows are produced by the returned plan.
+*/
+func (db *Database) viewTable(view *dbView, alias string) (*queryRelation, error) {
+	query, err := db.unmarshalQuery(view.definition)
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := db.plan(query)
+	if err != nil {
+		return nil, fmt.Errorf("View `%s' is invalid: %v", view.name, err)
+	}
+
+	relation := view.name
+	if alias != "" {
+		relation = alias
+	}
+
+	table := newDBTable(view.dbViewID, dbChar(relation), []dbColumn{}, dbInteger(nullBlockAddr))
+	table.sourceName = view.name
+
+	for i, column := range plan.columns() {
+		_, columnName := splitIdentifier(column.name)
+
+		c := dbColumn{
+			dbColumnID:       dbInteger(i),
+			dbColumnPosition: dbInteger(i),
+			dbColumnName:     dbChar(columnName),
+			dbTypeID:         column.typeID,
+			dbTypeSize:       column.size,
+		}
+
+		if !column.nullable {
+			c.addConstraint(dbNotNullConstraint)
+		}
+
+		if err := table.addColumn(c); err != nil {
+			return nil, fmt.Errorf("Column `%s' appears more than once in view `%s'", columnName, view.name)
+		}
+	}
+
+	return &queryRelation{dbTable: table, view: plan, subqueries: query.expressions()}, nil
+}
+
+/*
+checkNoDependentViews fails if a view reads from the table or view called name, which
+therefore can't be dropped.
+*/
+func (db *Database) checkNoDependentViews(name string) error {
+	definitions := map[string]string{}
+	for _, view := range db.dbViews {
+		definitions[view.name] = view.definition
+	}
+	for _, view := range db.dbMaterializedViews {
+		definitions[view.name] = view.definition
+	}
+
+	for viewName, definition := range definitions {
+		query, err := db.unmarshalQuery(definition)
+		if err != nil {
+			continue
+		}
+
+		for _, reference := range queryReferences(query) {
+			if reference == name {
+				return fmt.Errorf("`%s' is used by view `%s'", name, viewName)
+			}
+		}
+	}
+
+	return nil
+}
+
+// queryReferences returns the names of the tables and views read by query and its subqueries.
+func queryReferences(query selectQuery) (names []string) {
+	names = append(names, query.table)
+	for _, clause := range query.joins {
+		names = append(names, clause.table)
+	}
+
+	for _, expr := range query.expressions() {
+		names = append(names, subqueryReferences(expr)...)
+	}
+
+	return names
+}
+
+func subqueryReferences(expr common.Expression) (names []string) {
+	if subquery, ok := expr.(*SubqueryExpression); ok {
+		names = append(names, queryReferences(subquery.query)...)
+	}
+
+	if composite, ok := expr.(compositeExpression); ok {
+		for _, operand := range composite.operands() {
+			names = append(names, subqueryReferences(operand)...)
+		}
+	}
+
+	return names
+}
+
+// viewScan renames the columns of a view's result to those of the referencing relation.
+func (n *scanNode) viewScan(ctx context.Context, db *Database, emit tupleEmitter) error {
+	source := n.view.columns()
+	resetSubqueries(ctx, n.viewSubqueries...)
+
+	return n.view.run(ctx, db, func(tuple dbTuple) (bool, error) {
+		renamed := dbTuple{}
+		for i, column := range source {
+			renamed[n.table.dbColumns[i].name()] = tuple[column.name]
+		}
+
+		if ok, err := evaluatePredicates(n.filters, renamed); err != nil || !ok {
+			return err == nil, err
+		}
+
+		return emit(renamed)
+	})
+}
@@ -0,0 +1,72 @@
+package data
+
+import "context"
+
+// ResultColumn describes a column of a ResultSet.
+type ResultColumn struct {
+	ColumnName string
+	TableName  string
+	ColumnType dbTypeID
+	ColumnSize uint16
+	Nullable   bool
+}
+
+/*
+ResultSet holds the result of a select. Columns are listed in projection order, and
+every row holds one value per column in the same order.
+*/
+type ResultSet struct {
+	Columns []ResultColumn
+	Rows    [][]interface{}
+}
+
+// Maps returns every row as a map from column name to value.
+func (rs *ResultSet) Maps() []map[string]interface{} {
+	maps := []map[string]interface{}{}
+
+	for _, row := range rs.Rows {
+		values := map[string]interface{}{}
+		for i, column := range rs.Columns {
+			values[column.ColumnName] = row[i]
+		}
+		maps = append(maps, values)
+	}
+
+	return maps
+}
+
+// resultSet runs plan and collects its rows in the order of the plan's columns.
+func (db *Database) resultSet(ctx context.Context, plan planNode) (*ResultSet, error) {
+	columns := plan.columns()
+	rs := &ResultSet{Rows: [][]interface{}{}}
+
+	err := plan.run(ctx, db, func(tuple dbTuple) (bool, error) {
+		row := make([]interface{}, len(columns))
+		for i, column := range columns {
+			value := tuple[column.name]
+			if value != nil && !column.typed {
+				// The type of some computed columns is only known from their values.
+				columns[i].typeID, columns[i].typed = value.dbTypeID(), true
+			}
+			row[i] = stdType(value)
+		}
+
+		rs.Rows = append(rs.Rows, row)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, column := range columns {
+		rs.Columns = append(rs.Columns, ResultColumn{
+			ColumnName: column.name,
+			TableName:  column.table,
+			ColumnType: column.typeID,
+			ColumnSize: uint16(column.size),
+			Nullable:   column.nullable,
+		})
+	}
+
+	return rs, nil
+}
@@ -1,8 +1,12 @@
 package data
 
 type Table struct {
-	TableName    string
-	TableColumns []TableColumn
+	TableName         string
+	TableColumns      []TableColumn
+	UniqueConstraints []UniqueConstraint
+	CheckConstraints  []CheckConstraint
+	// Statistics is nil until the table is analyzed.
+	Statistics *TableStatistics
 }
 
 type TableColumn struct {
@@ -14,6 +18,7 @@ type TableColumn struct {
 	PrimaryKey    bool
 	ForeignKey    bool
 	DefaultValue  bool
+	Unique        bool
 }
 
 func dbColumnToTableColumn(c dbColumn) TableColumn {
@@ -26,6 +31,7 @@ func dbColumnToTableColumn(c dbColumn) TableColumn {
 		PrimaryKey:    c.hasConstraint(dbPrimaryKeyConstraint),
 		ForeignKey:    c.hasConstraint(dbForeignKeyConstraint),
 		DefaultValue:  c.hasConstraint(dbDefaultValueConstraint),
+		Unique:        c.hasConstraint(dbUniqueConstraint),
 	}
 }
 
@@ -35,9 +41,27 @@ func dbTableToTable(t dbTable) *Table {
 		columns = append(columns, dbColumnToTableColumn(c))
 	}
 
+	var statistics *TableStatistics
+	if t.statistics != nil {
+		statistics = t.statistics.public(t)
+	}
+
+	var uniques []UniqueConstraint
+	for _, u := range t.uniques {
+		uniques = append(uniques, u.public())
+	}
+
+	var checks []CheckConstraint
+	for _, c := range t.checks {
+		checks = append(checks, c.public())
+	}
+
 	return &Table{
-		TableName:    t.name(),
-		TableColumns: columns,
+		TableName:         t.name(),
+		TableColumns:      columns,
+		Statistics:        statistics,
+		UniqueConstraints: uniques,
+		CheckConstraints:  checks,
 	}
 }
 